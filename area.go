@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+)
+
+// Area is a set of tiles painted in one colour on the map, or on the scenario's mask image. Points are kept in the
+// order they were added so everything built from them is deterministic.
+type Area struct {
+	points []image.Point
+	set    map[image.Point]bool
+}
+
+func (a *Area) add(p image.Point) {
+	if a.set == nil {
+		a.set = make(map[image.Point]bool)
+	}
+	if !a.set[p] {
+		a.set[p] = true
+		a.points = append(a.points, p)
+	}
+}
+
+func (a *Area) addArea(other *Area) {
+	for _, p := range other.points {
+		a.add(p)
+	}
+}
+
+func (a *Area) Contains(x, y int) bool {
+	return a.set[image.Point{X: x, Y: y}]
+}
+
+func (a *Area) Points() []image.Point {
+	return a.points
+}
+
+func (a *Area) Len() int {
+	return len(a.points)
+}
+
+// centre is the mean of the area's tiles
+func (a *Area) centre() (float64, float64) {
+	var x, y float64
+	for _, p := range a.points {
+		x += float64(p.X) + 0.5
+		y += float64(p.Y) + 0.5
+	}
+	return x / float64(len(a.points)), y / float64(len(a.points))
+}
+
+// readMask loads the scenario's mask image, or uses the map itself when there isn't one
+func (s *State) readMask() (image.Image, error) {
+	if s.scenario.Mask == "" {
+		return s.background, nil
+	}
+	data, err := ioutil.ReadFile(s.scenario.Mask)
+	if err != nil {
+		return nil, err
+	}
+	mask, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if mask.Bounds().Dx() != s.width || mask.Bounds().Dy() != s.height {
+		return nil, fmt.Errorf("mask is %dx%d but the map is %dx%d", mask.Bounds().Dx(), mask.Bounds().Dy(), s.width, s.height)
+	}
+	return mask, nil
+}
+
+// ReadAreas fills in the area of every destination and region which has a colour, from the walkable tiles painted
+// in that colour on the mask. Colours which can't be parsed are left for validateAreas to report.
+func (s *State) ReadAreas() error {
+	type painted struct {
+		colour color.RGBA
+		area   *Area
+	}
+	var wanted []painted
+	addWanted := func(colour string, area *Area) {
+		if colour == "" {
+			return
+		}
+		c, err := parseColour(colour)
+		if err == nil {
+			wanted = append(wanted, painted{c, area})
+		}
+	}
+	for i := range s.scenario.Destinations {
+		addWanted(s.scenario.Destinations[i].Colour, &s.scenario.Destinations[i].area)
+	}
+	addWanted(s.scenario.Exit.Colour, &s.scenario.Exit.area)
+	for i := range s.Regions {
+		addWanted(s.Regions[i].Colour, &s.Regions[i].area)
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	mask, err := s.readMask()
+	if err != nil {
+		return err
+	}
+	bounds := mask.Bounds()
+	for x := 0; x < s.width; x++ {
+		for y := 0; y < s.height; y++ {
+			if !s.tiles[x][y].walkable {
+				continue
+			}
+			c := mask.At(bounds.Min.X+x, bounds.Min.Y+y)
+			for _, w := range wanted {
+				if sameColour(w.colour, c) {
+					w.area.add(image.Point{X: x, Y: y})
+				}
+			}
+		}
+	}
+
+	// regions drawn only on the mask are placed at the middle of their area
+	for i, r := range s.Regions {
+		if r.Radius == 0 && r.area.Len() > 0 {
+			s.Regions[i].X, s.Regions[i].Y = r.area.centre()
+		}
+	}
+	s.indexRegions()
+	return nil
+}
+
+// validateAreas checks every colour used for an area can be parsed and is painted somewhere walkable
+func (s *State) validateAreas() []ValidationError {
+	var problems []ValidationError
+	check := func(path, colour string, area *Area) {
+		if colour == "" {
+			return
+		}
+		if _, err := parseColour(colour); err != nil {
+			problems = append(problems, ValidationError{path, err.Error()})
+		} else if area.Len() == 0 {
+			problems = append(problems, ValidationError{path, fmt.Sprintf("no walkable tiles are painted %s", colour)})
+		}
+	}
+	for i := range s.scenario.Destinations {
+		check(fmt.Sprintf("$.Destinations[%d].colour", i), s.scenario.Destinations[i].Colour, &s.scenario.Destinations[i].area)
+	}
+	check("$.exit.colour", s.scenario.Exit.Colour, &s.scenario.Exit.area)
+	for _, r := range s.Regions {
+		if r.Colour != "" {
+			if _, err := parseColour(r.Colour); err != nil {
+				problems = append(problems, ValidationError{"$.regions", fmt.Sprintf("region %d (%s): %s", r.ID, r.Name, err)})
+			} else if r.area.Len() == 0 {
+				problems = append(problems, ValidationError{"$.regions", fmt.Sprintf("region %d (%s): no walkable tiles are painted %s", r.ID, r.Name, r.Colour)})
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadAreasFromMap(t *testing.T) {
+	w := gridWorld(
+		"..gg",
+		"#.gg",
+		"....",
+	)
+	w.scenario.Destinations = []Destination{{Name: "lawn", Colour: "#00c800"}}
+	w.Regions = []Region{{ID: 1, Name: "lawn", Colour: "#00c800"}}
+	if err := w.ReadAreas(); err != nil {
+		t.Fatal(err)
+	}
+	lawn := &w.scenario.Destinations[0]
+	if lawn.area.Len() != 4 {
+		t.Errorf("destination has %d tiles, want the 4 painted", lawn.area.Len())
+	}
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 3; y++ {
+			painted := x >= 2 && y <= 1
+			if lawn.Contains(x, y) != painted {
+				t.Errorf("destination contains (%d, %d) is %t, want %t", x, y, !painted, painted)
+			}
+			if w.Regions[0].Contains(float64(x)+0.5, float64(y)+0.5) != painted {
+				t.Errorf("region contains (%d, %d) is %t, want %t", x, y, !painted, painted)
+			}
+		}
+	}
+	if r := w.Regions[0]; r.X != 3 || r.Y != 1 {
+		t.Errorf("region is placed at %v, %v, want the middle of its area at 3, 1", r.X, r.Y)
+	}
+}
+
+func TestReadAreasFromMask(t *testing.T) {
+	w := gridWorld(
+		"...",
+		"#..",
+	)
+	dir := t.TempDir()
+	mask := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	red := color.RGBA{R: 0xff, A: 0xff}
+	mask.Set(0, 0, red)
+	mask.Set(0, 1, red) // a wall, so not part of the area
+	w.scenario.Mask = writeTestImage(t, filepath.Join(dir, "mask.png"), mask)
+	w.scenario.Exit = Destination{Name: "exit", Colour: "#ff0000"}
+	if err := w.ReadAreas(); err != nil {
+		t.Fatal(err)
+	}
+	if points := w.scenario.Exit.area.Points(); len(points) != 1 || points[0] != (image.Point{}) {
+		t.Errorf("exit is painted on %v, want only (0, 0)", points)
+	}
+
+	w.scenario.Mask = writeTestImage(t, filepath.Join(dir, "small.png"), image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	if err := w.ReadAreas(); err == nil {
+		t.Error("read a mask a different size from the map")
+	}
+}
+
+func writeTestImage(t *testing.T, path string, img image.Image) string {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/mockbackend"
+	"golang.org/x/exp/shiny/driver"
+	"golang.org/x/exp/shiny/screen"
+)
+
+// options holds every flag shared between the commands, each command only registers the ones it uses
+type options struct {
+	senders  int
+	sink     string
+	bulk     bool
+	seed     int64
+	speed    float64
+	out      string
+	gui      bool
+	trace    string
+	mock     bool
+	scenario string
+	updates  bool
+	dest     string
+	dists    bool
+	cache    string
+}
+
+func (o *options) senderFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.sink, "sink", "", "where to send updates: none, stdout, file:<path> or a backend base URL (defaults to the scenario's sink)")
+	fs.BoolVar(&o.bulk, "bulk", false, "send updates in bulk rather than one request per update")
+	fs.BoolVar(&o.mock, "mock-backend", false, "send updates to an in-process mock backend and check what it received")
+}
+
+// speedFlag registers -speed, replays defaulting to real time and runs to as fast as possible
+func (o *options) speedFlag(fs *flag.FlagSet, def float64) {
+	fs.Float64Var(&o.speed, "speed", def, "simulated seconds per real second, 0 for as fast as possible")
+}
+
+func (o *options) outFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.out, "out", "", "output directory for flow fields and renders (defaults to the scenario name)")
+	fs.StringVar(&o.cache, "cache", "", "directory to cache generated flow fields in (defaults to the user cache directory)")
+}
+
+func parse(fs *flag.FlagSet, args []string, what string) string {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rtfa-simulation %s [flags] <%s>\n", fs.Name(), what)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0)
+}
+
+func runCommand(args []string) {
+	var o options
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	o.senderFlags(fs)
+	o.speedFlag(fs, 0)
+	o.outFlag(fs)
+	fs.IntVar(&o.senders, "senders", 300, "maximum number of individuals sending updates at once")
+	fs.Int64Var(&o.seed, "seed", 0, "seed for a reproducible run (defaults to one picked from the clock)")
+	fs.BoolVar(&o.gui, "gui", true, "open the map and control panel windows")
+	fs.StringVar(&o.trace, "trace", "", "record every tick of the run to this trace file")
+	path := parse(fs, args, "scenario.json")
+
+	w := loadWorld(path, &o)
+	backend := o.startSenders(w)
+	if o.trace != "" {
+		var err error
+		w.recorder, err = NewTraceRecorder(o.trace, TraceHeader{Scenario: path, Seed: w.Seed,
+			Sensors: w.sensing(), Debounced: w.sendsDebounced()})
+		if err != nil {
+			log.Fatalln("cannot create trace:", err)
+		}
+	}
+	log.Println("loaded scenario")
+
+	if !o.gui {
+		RunHeadless(w)
+		o.checkBackend(w, backend)
+		return
+	}
+	runGUI(w, func(r *RenderState) {
+		simulate(w, r)
+	})
+}
+
+func flowFieldsCommand(args []string) {
+	if len(args) < 1 || (args[0] != "generate" && args[0] != "show") {
+		fmt.Fprint(os.Stderr, "usage: rtfa-simulation flowfields generate|show [flags] <scenario.json>\n")
+		os.Exit(2)
+	}
+	var o options
+	fs := flag.NewFlagSet("flowfields "+args[0], flag.ExitOnError)
+	o.outFlag(fs)
+	if args[0] == "show" {
+		fs.StringVar(&o.dest, "dest", "", "name of the destination to show")
+		fs.BoolVar(&o.dists, "distances", false, "print distances rather than directions")
+	}
+	path := parse(fs, args[1:], "scenario.json")
+	w := loadWorld(path, &o)
+
+	if args[0] == "generate" {
+		w.GenerateFlowFields()
+		for _, dest := range w.scenario.Destinations {
+			err := w.SaveFlowField(dest.ID)
+			if err != nil {
+				log.Fatal("cannot save flow field for ", dest.Name, ": ", err)
+			}
+		}
+		log.Println("Flow fields saved to", w.flowFieldDir())
+		return
+	}
+
+	for _, dest := range w.scenario.Destinations {
+		if dest.Name != o.dest {
+			continue
+		}
+		err := w.LoadFlowField(dest.ID)
+		if err != nil {
+			log.Fatalln("cannot load flow field:", err)
+		}
+		if o.dists {
+			w.PrintDistances(dest.ID)
+		} else {
+			w.PrintDirections(dest.ID)
+		}
+		return
+	}
+	log.Fatalf("no destination called %q", o.dest)
+}
+
+func validateCommand(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := parse(fs, args, "scenario.json")
+	_, problems := ReadScenario(path)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%s has %d problem(s)\n", path, len(problems))
+		os.Exit(1)
+	}
+	fmt.Println(path, "is valid")
+}
+
+func renderCommand(args []string) {
+	var o options
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	o.outFlag(fs)
+	path := parse(fs, args, "scenario.json")
+	w := loadWorld(path, &o)
+	out, err := w.RenderOverview()
+	if err != nil {
+		log.Fatalln("cannot render scenario:", err)
+	}
+	fmt.Println("rendered", out)
+}
+
+func replayCommand(args []string) {
+	var o options
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	o.senderFlags(fs)
+	o.speedFlag(fs, 1)
+	fs.BoolVar(&o.gui, "gui", false, "show the replay in the map window (traces only)")
+	fs.BoolVar(&o.updates, "updates", false, "the file holds updates to re-send rather than a trace")
+	fs.StringVar(&o.scenario, "scenario", "", "scenario to replay the trace on (defaults to the one it was recorded with)")
+	path := parse(fs, args, "file")
+
+	if o.updates {
+		updates, err := ReadUpdates(path)
+		if err != nil {
+			log.Fatalln("cannot read updates:", err)
+		}
+		w := &State{BulkSend: o.bulk}
+		w.MakeChannes()
+		backend := o.startSenders(w)
+		done := w.drainStats()
+		w.ReplayUpdates(updates, o.speed)
+		w.StopSenders()
+		close(done)
+		o.checkBackend(w, backend)
+		return
+	}
+
+	trace, err := OpenTrace(path)
+	if err != nil {
+		log.Fatalln("cannot open trace:", err)
+	}
+	defer trace.Close()
+	if o.scenario == "" {
+		o.scenario = trace.Header.Scenario
+	}
+	o.seed = trace.Header.Seed
+	w := loadWorld(o.scenario, &o)
+	backend := o.startSenders(w)
+
+	if !o.gui {
+		done := w.drainStats()
+		Replay(w, trace, nil, o.speed)
+		w.StopSenders()
+		close(done)
+		o.checkBackend(w, backend)
+		return
+	}
+	runGUI(w, func(r *RenderState) {
+		Replay(w, trace, r, o.speed)
+	})
+}
+
+func loadWorld(path string, o *options) *State {
+	w := LoadScenario(path)
+	w.MakeChannes()
+	if o.out != "" {
+		w.OutputDir = o.out
+	}
+	w.CacheDir = o.cache
+	if o.seed == 0 {
+		o.seed = time.Now().UnixNano()
+	}
+	w.SetSeed(o.seed)
+	log.Println("seed:", o.seed)
+	w.BulkSend = o.bulk
+	w.maxSenders = o.senders
+	w.Speed = o.speed
+	return &w
+}
+
+func (o *options) startSenders(w *State) *mockbackend.Server {
+	var backend *mockbackend.Server
+	var sink UpdateSink
+	if o.mock {
+		backend, sink = w.StartMockBackend()
+	} else {
+		scenario := w.scenario
+		if scenario == nil {
+			scenario = &Scenario{}
+		}
+		var err error
+		sink, err = scenario.ChooseSink(o.sink)
+		if err != nil {
+			log.Fatalln("cannot create update sink:", err)
+		}
+	}
+	w.StartSenders(sink)
+	return backend
+}
+
+func (o *options) checkBackend(w *State, backend *mockbackend.Server) {
+	if backend != nil && !w.CheckMockBackend(backend) {
+		os.Exit(1)
+	}
+}
+
+// runGUI opens the map and control panel and calls run on its own goroutine
+func runGUI(w *State, run func(r *RenderState)) {
+	driver.Main(func(s screen.Screen) {
+		r := SetupRender(s, w.GetImage(), &w.Regions, w)
+		defer r.Release()
+
+		go run(&r)
+
+		for r.Step() {
+		}
+		fmt.Println("EOL")
+
+	})
+
+	fmt.Println("Bottom")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/mockbackend"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Parse()
+
+	s := mockbackend.NewServer()
+	url, err := s.Listen(*addr)
+	if err != nil {
+		log.Fatalln("cannot start mock backend:", err)
+	}
+	log.Println("mock backend listening on", url)
+
+	for range time.Tick(10 * time.Second) {
+		log.Println("received", len(s.Updates()), "updates in", s.Requests(), "requests,", len(s.Problems()), "problems")
+		log.Println("occupancy:", s.Occupancy())
+	}
+}
@@ -4,49 +4,21 @@ import (
 	"math"
 )
 
-const (
-	PersonRadius             = 0.2
-	TwicePersonRadius        = 2 * PersonRadius
-	TwicePersonRadiusSquared = (TwicePersonRadius) * (TwicePersonRadius)
-	OneMinusTwiceRadius      = 1 - TwicePersonRadius
-)
-
-func (s *State) movementIntersects(x, y float64, theta float64, distance float64) (bool, float64, float64) {
+// PersonRadius is in metres, State.personRadius is the same in tiles. It is everyone's radius unless the scenario
+// gives People.
+const PersonRadius = 0.2
+
+// movementIntersects moves someone of the given radius from x, y, stopping short at walls and other people, each of
+// whom has their own radius
+func (s *State) movementIntersects(x, y, radius float64, theta float64, distance float64) (bool, float64, float64) {
+	// how close to the edge of a tile someone in the next can be touched
+	reach := radius + s.maxRadius
+	oneMinusReach := 1 - reach
 	tx, ty := int(x), int(y)
-	nx := x + distance*math.Cos(theta)
-	ny := y + distance*math.Sin(theta)
-	collided := false
+	nx, ny, collided := s.slide(x, y, radius, distance*math.Cos(theta), distance*math.Sin(theta))
 
 	smallnx := nx - math.Floor(x)
 	smallny := ny - math.Floor(y)
-	// Possibly worry about running off the edge of the map?
-	if smallnx < PersonRadius {
-		// intersect the x-- tile
-		if !s.GetTile(tx-1, ty).Walkable() {
-			nx = math.Floor(x) + PersonRadius
-			collided = true
-		}
-	} else if smallnx > 1-PersonRadius {
-		// intersect the x++ tile
-		if !s.GetTile(tx+1, ty).Walkable() {
-			nx = math.Floor(x) + 1 - PersonRadius
-			collided = true
-		}
-	}
-	if smallny < PersonRadius {
-		// intersect the y-- tile
-		if !s.GetTile(tx, ty-1).Walkable() {
-			ny = math.Floor(y) + PersonRadius
-			collided = true
-		}
-	} else if smallny > 1-PersonRadius {
-		// intersect the y++ tile
-		if !s.GetTile(tx, ty+1).Walkable() {
-			ny = math.Floor(y) + 1 - PersonRadius
-			collided = true
-		}
-	}
-
 	if collided {
 		distance = math.Sqrt(math.Pow(x-nx, 2) + math.Pow(y-ny, 2))
 		theta = math.Atan2(ny-y, nx-x)
@@ -56,12 +28,12 @@ func (s *State) movementIntersects(x, y float64, theta float64, distance float64
 
 	var sx int
 	var fx int
-	if smallnx < TwicePersonRadius {
+	if smallnx < reach {
 		sx = -1
 	} else {
 		sx = 0
 	}
-	if smallnx > OneMinusTwiceRadius {
+	if smallnx > oneMinusReach {
 		fx = 2
 	} else {
 		fx = 1
@@ -69,12 +41,12 @@ func (s *State) movementIntersects(x, y float64, theta float64, distance float64
 
 	var sy int
 	var fy int
-	if smallny < TwicePersonRadius {
+	if smallny < reach {
 		sy = -1
 	} else {
 		sy = 0
 	}
-	if smallny > OneMinusTwiceRadius {
+	if smallny > oneMinusReach {
 		fy = 2
 	} else {
 		fy = 1
@@ -87,12 +59,13 @@ func (s *State) movementIntersects(x, y float64, theta float64, distance float64
 			if tile != nil {
 				for i, _ := range tile.People {
 					ax, ay := tile.People[i].Loc.GetLatestXY()
-					if !(ax == x && ay == y) && intersect(nx, ny, ax, ay) {
+					apart := radius + tile.People[i].radius
+					if !(ax == x && ay == y) && intersect(nx, ny, ax, ay, apart) {
 						collided = true
 						cx, cy := closestPointOnLine(x, y, nx, ny, ax, ay)
 
 						closestSquared := math.Pow(cx-ax, 2) + math.Pow(cy-ay, 2)
-						backdist := math.Sqrt(math.Dim(TwicePersonRadiusSquared, closestSquared))
+						backdist := math.Sqrt(math.Dim(apart*apart, closestSquared))
 
 						distance = math.Dim(distance, backdist)
 
@@ -109,23 +82,72 @@ func (s *State) movementIntersects(x, y float64, theta float64, distance float64
 	return collided, nx, ny
 }
 
-func intersect(ax, ay, bx, by float64) bool {
-	return math.Pow(ax-bx, 2)+math.Pow(ay-by, 2) < TwicePersonRadiusSquared
+// slide sweeps a circle of the given radius from x, y by dx, dy in steps short enough not to pass through a corner,
+// pushing it back out of any wall it touches, diagonal neighbours and the edge of the map included. What is left of the
+// movement carries on along the wall rather than stopping dead.
+func (s *State) slide(x, y, radius, dx, dy float64) (float64, float64, bool) {
+	collided := false
+	steps := int(math.Ceil(math.Hypot(dx, dy) / (math.Max(radius, 0.1) / 2)))
+	for i := 0; i < steps; i++ {
+		px, py := x+dx/float64(steps), y+dy/float64(steps)
+		for pass := 0; pass < 2; pass++ {
+			pushed := false
+			for ix := int(math.Floor(px)) - 1; ix <= int(math.Floor(px))+1; ix++ {
+				for iy := int(math.Floor(py)) - 1; iy <= int(math.Floor(py))+1; iy++ {
+					if s.GetTile(ix, iy).Walkable() {
+						continue
+					}
+					// nearest point of the wall tile to the centre
+					cx := math.Max(float64(ix), math.Min(float64(ix+1), px))
+					cy := math.Max(float64(iy), math.Min(float64(iy+1), py))
+					d := math.Hypot(px-cx, py-cy)
+					if d >= radius {
+						continue
+					}
+					if d == 0 {
+						// the centre has got into the wall, so go back
+						px, py = x, y
+					} else {
+						px += (px - cx) / d * (radius - d)
+						py += (py - cy) / d * (radius - d)
+					}
+					pushed = true
+				}
+			}
+			if !pushed {
+				break
+			}
+			collided = true
+		}
+		if !s.GetTile(int(math.Floor(px)), int(math.Floor(py))).Walkable() {
+			return x, y, true
+		}
+		x, y = px, py
+	}
+	return x, y, collided
+}
+
+// intersect reports whether two people are closer than apart, the sum of their radii
+func intersect(ax, ay, bx, by, apart float64) bool {
+	return math.Pow(ax-bx, 2)+math.Pow(ay-by, 2) < apart*apart
 }
 
-func (s *State) IntersectsAnyone(x, y float64) bool {
+// IntersectsAnyone reports whether someone of the given radius at x, y would overlap anyone else
+func (s *State) IntersectsAnyone(x, y, radius float64) bool {
+	reach := radius + s.maxRadius
+	oneMinusReach := 1 - reach
 	tx := int(x)
 	ty := int(y)
 	smallnx := x - math.Floor(x)
 	smallny := y - math.Floor(y)
 	var sx int
 	var fx int
-	if smallnx < TwicePersonRadius {
+	if smallnx < reach {
 		sx = -1
 	} else {
 		sx = 0
 	}
-	if smallnx > OneMinusTwiceRadius {
+	if smallnx > oneMinusReach {
 		fx = 2
 	} else {
 		fx = 1
@@ -133,12 +155,12 @@ func (s *State) IntersectsAnyone(x, y float64) bool {
 
 	var sy int
 	var fy int
-	if smallny < TwicePersonRadius {
+	if smallny < reach {
 		sy = -1
 	} else {
 		sy = 0
 	}
-	if smallny > OneMinusTwiceRadius {
+	if smallny > oneMinusReach {
 		fy = 2
 	} else {
 		fy = 1
@@ -151,7 +173,7 @@ func (s *State) IntersectsAnyone(x, y float64) bool {
 			if tile != nil {
 				for i, _ := range tile.People {
 					ax, ay := tile.People[i].Loc.GetLatestXY()
-					if !(ax == x && ay == y) && intersect(x, y, ax, ay) {
+					if !(ax == x && ay == y) && intersect(x, y, ax, ay, radius+tile.People[i].radius) {
 						return true
 					}
 				}
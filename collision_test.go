@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSlide(t *testing.T) {
+	tests := []struct {
+		name                 string
+		rows                 []string
+		x, y, dx, dy         float64
+		wantX, wantY         float64
+		wantCollided, approx bool
+	}{
+		{"open floor", []string{"....", "...."}, 0.5, 0.5, 1, 0.5, 1.5, 1, false, false},
+		{"along a wall", []string{"#####", "....."}, 1.5, 1.5, 1, -1, 2.5, 1.2, true, true},
+		{"edge of the map", []string{"...."}, 1.5, 0.5, -2, 0, 0.2, 0.5, true, false},
+		{"through a wall", []string{"..#.."}, 0.5, 0.5, 3, 0, 1.8, 0.5, true, false},
+		// the only way on is between the corners of two walls, which is too narrow
+		{"between diagonal corners", []string{".#", "#."}, 0.5, 0.5, 1, 1, 0.8, 0.8, true, true},
+	}
+	for _, test := range tests {
+		w := gridWorld(test.rows...)
+		x, y, collided := w.slide(test.x, test.y, 0.2, test.dx, test.dy)
+		if collided != test.wantCollided {
+			t.Errorf("%s: collided %v, want %v", test.name, collided, test.wantCollided)
+		}
+		tolerance := 1e-9
+		if test.approx {
+			tolerance = 0.1
+		}
+		if math.Abs(x-test.wantX) > tolerance || math.Abs(y-test.wantY) > tolerance {
+			t.Errorf("%s: ended at (%v, %v), want (%v, %v)", test.name, x, y, test.wantX, test.wantY)
+		}
+		if !w.GetTile(int(x), int(y)).Walkable() {
+			t.Errorf("%s: ended in a wall at (%v, %v)", test.name, x, y)
+		}
+	}
+}
+
+func TestMovementStopsShortOfPeople(t *testing.T) {
+	w := gridWorld("......", "......")
+	w.maxRadius = 0.2
+	stand(w, "b", 3.5, 0.5)
+	collided, x, y := w.movementIntersects(2.5, 0.5, 0.2, 0, 0.8)
+	if !collided || x <= 2.5 || x > 3.1+1e-9 || y != 0.5 {
+		t.Errorf("walking into someone ended at (%v, %v), want short of touching them at (3.1, 0.5)", x, y)
+	}
+	if w.IntersectsAnyone(3, 0.5, 0.2) || !w.IntersectsAnyone(3.2, 0.5, 0.2) {
+		t.Error("overlaps were found where people don't touch or missed where they do")
+	}
+	// walking past someone with room to spare isn't stopped
+	if collided, x, _ := w.movementIntersects(2.5, 1.5, 0.2, 0, 0.8); collided || math.Abs(x-3.3) > 1e-9 {
+		t.Errorf("walking past someone collided %v at x = %v", collided, x)
+	}
+}
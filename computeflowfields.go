@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
 	_ "image/png"
-	"log"
 	"math"
 )
 
@@ -77,12 +76,12 @@ func (d Direction) String() string {
 }
 
 func (w *State) PrintDirections(destination DestinationID) {
+	field := w.FlowField(destination)
 
 	for i := 0; i < w.GetHeight(); i++ {
 		for j := 0; j < w.GetWidth(); j++ {
 
-			tile := w.GetTile(j, i)
-			angle, present := tile.Directions[destination].Value()
+			angle, present := field.Direction(j, i).Value()
 			if !present || angle == math.Inf(1) {
 				fmt.Print("## ")
 			} else {
@@ -98,13 +97,12 @@ func (w *State) PrintDirections(destination DestinationID) {
 }
 
 func (w *State) PrintDistances(destination DestinationID) {
+	field := w.FlowField(destination)
 
 	for i := 0; i < w.GetHeight(); i++ {
 		for j := 0; j < w.GetWidth(); j++ {
 
-			tile := w.GetTile(j, i)
-
-			destToPrint := tile.Dists[destination]
+			destToPrint := field.Dist(j, i)
 			if destToPrint == math.Inf(1) {
 				fmt.Print("## ")
 			} else {
@@ -121,128 +119,98 @@ func (w *State) PrintDistances(destination DestinationID) {
 
 // Generates the flow field to the Destination
 func (w *State) GenerateFlowField(destination DestinationID) error {
-	log.Println("find shorted path")
-	FindShortestPath(w, destination)
-	log.Println("compute directions")
-	w.computeDirections(destination)
-
+	w.setFlowField(destination, w.computeFlowField(destination))
 	return nil
 
 }
 
+func (w *State) computeFlowField(destination DestinationID) *FlowField {
+	field := NewFlowField(w.GetWidth(), w.GetHeight())
+	FindShortestPath(w, destination, field)
+	w.computeDirections(field)
+	return field
+}
+
 // Assuming that distance information has been filled in by dijkstra,
 // calculate the directions needed
-func (w *State) computeDirections(dest DestinationID) {
+func (w *State) computeDirections(field *FlowField) {
 
 	for i := 0; i < w.GetWidth(); i++ {
 		for j := 0; j < w.GetHeight(); j++ {
-			computeDirectionForTile(i, j, dest, w)
+			computeDirectionForTile(i, j, field, w)
 		}
 	}
 
 }
-func computeDirectionForTile(x int, y int, dest DestinationID, w *State) {
+func computeDirectionForTile(x int, y int, field *FlowField, w *State) {
 
 	tile := w.GetTile(x, y)
 
-	if tile.Directions == nil {
-		tile.Directions = make(map[DestinationID]utils.OptionalFloat64)
-	}
-
-	// Skip walls
-	if !tile.Walkable() {
-		tile.Directions[dest] = utils.OptionalFloat64WithEmptyValue()
+	// Skip walls, and tiles the destination can't be reached from
+	if !tile.Walkable() || math.IsInf(field.Dist(x, y), 1) {
+		field.Directions[field.index(x, y)] = utils.OptionalFloat64WithEmptyValue()
 		return
 	}
 
-	// Find the next as-the-crow-flies Destination tile
-	destX := x
-	destY := y
-
-	// Work out whether we should look up or down
-	stepY := 0
-	validUpCoord := w.validCoord(destX, destY+1)
-	validDownCoord := w.validCoord(destX, destY-1)
-	if !validUpCoord && !validDownCoord {
-		stepY = 0
-	} else if !validUpCoord && w.GetTile(destX, destY-1).Dists[dest] < tile.Dists[dest] {
-		stepY = -1
-	} else if !validDownCoord && w.GetTile(destX, destY+1).Dists[dest] < tile.Dists[dest] {
-		stepY = 1
-	} else if validUpCoord && validDownCoord &&
-		w.GetTile(destX, destY+1).Dists[dest] < w.GetTile(destX, destY-1).Dists[dest] &&
-		w.GetTile(destX, destY+1).Dists[dest] < tile.Dists[dest] {
-		stepY = 1
-	} else if validUpCoord && validDownCoord &&
-		w.GetTile(destX, destY-1).Dists[dest] < w.GetTile(destX, destY+1).Dists[dest] &&
-		w.GetTile(destX, destY-1).Dists[dest] < tile.Dists[dest] {
-		stepY = -1
-	}
-
-	// Find how far we have to go in the y-axis
-	if stepY != 0 {
-		for newDestY := destY + stepY; w.validCoord(destX, newDestY) && w.GetTile(destX, newDestY).Walkable() && w.GetTile(destX, newDestY).Dists[dest] < w.GetTile(destX, destY).Dists[dest]; newDestY += stepY {
-			destY = newDestY
+	// Walk down the gradient of the distance field, as long as that doesn't lead into a wall
+	gx := w.distanceSlope(field, x, y, 1, 0)
+	gy := w.distanceSlope(field, x, y, 0, 1)
+	if gx != 0 || gy != 0 {
+		theta := math.Atan2(-gy, -gx)
+		dx := int(math.Round(math.Cos(theta)))
+		dy := int(math.Round(math.Sin(theta)))
+		next := validStep(tile, dx, dy, w)
+		if next != nil && field.Dist(next.X, next.Y) < field.Dist(x, y) {
+			field.Directions[field.index(x, y)] = utils.OptionalFloat64WithValue(theta)
+			return
 		}
 	}
 
-	// Work out whether we should look left or right
-	stepX := 0
-	validRightCoord := w.validCoord(destX+1, destY)
-	validLeftCoord := w.validCoord(destX-1, destY)
-	if !validRightCoord && !validLeftCoord {
-		stepX = 0
-	} else if !validRightCoord && w.GetTile(destX-1, destY).Dists[dest] < w.GetTile(destX, destY).Dists[dest] {
-		stepX = -1
-	} else if !validLeftCoord && w.GetTile(destX+1, destY).Dists[dest] < w.GetTile(destX, destY).Dists[dest] {
-		stepX = 1
-	} else if validLeftCoord && validRightCoord &&
-		w.GetTile(destX+1, destY).Dists[dest] < w.GetTile(destX-1, destY).Dists[dest] &&
-		w.GetTile(destX+1, destY).Dists[dest] < w.GetTile(destX, destY).Dists[dest] {
-		stepX = 1
-	} else if validLeftCoord && validRightCoord &&
-		w.GetTile(destX-1, destY).Dists[dest] < w.GetTile(destX+1, destY).Dists[dest] &&
-		w.GetTile(destX-1, destY).Dists[dest] < w.GetTile(destX, destY).Dists[dest] {
-		stepX = -1
-	}
-
-	// Find out how far we have to go in the x-axis
-	if stepX != 0 {
-		for newDestX := destX + stepX; w.validCoord(newDestX, destY) && w.GetTile(newDestX, destY).Walkable() && w.GetTile(newDestX, destY).Dists[dest] < w.GetTile(destX, destY).Dists[dest] && w.noWallsInCol(destX, y, destY); newDestX += stepX {
-			destX = newDestX
+	// Otherwise head for the neighbour which is closest to the destination
+	best := field.Dist(x, y)
+	var bestTile *Tile
+	for _, neighbour := range getValidNeighbouringTiles(tile, w) {
+		d := field.Dist(neighbour.X, neighbour.Y) + stepCost(tile, neighbour)
+		if d < best {
+			best = d
+			bestTile = neighbour
 		}
 	}
+	if bestTile == nil {
+		// Already at the destination
+		field.Directions[field.index(x, y)] = utils.OptionalFloat64WithValue(0)
+		return
+	}
+	field.Directions[field.index(x, y)] = utils.OptionalFloat64WithValue(math.Atan2(float64(bestTile.Y-y), float64(bestTile.X-x)))
 
-	// TODO: Follow this angle UNTIL the difference between the
-	// suggested angle at the square you are at and the current angle is greater than some threshold
-	// Add randomness to the angle chosen - but take this into account when using the threshold^
-
-	tile.Directions[dest] = utils.OptionalFloat64WithValue(math.Atan2(float64(destY-y), float64(destX-x)))
+}
 
+// distanceSlope is the rate the distance to the destination changes moving along dx, dy from x, y, using a central
+// difference where both sides are walkable and a one sided difference where only one is
+func (w *State) distanceSlope(field *FlowField, x, y, dx, dy int) float64 {
+	d := field.Dist(x, y)
+	forward, back := math.Inf(1), math.Inf(1)
+	if w.validCoord(x+dx, y+dy) && w.GetTile(x+dx, y+dy).Walkable() {
+		forward = field.Dist(x+dx, y+dy)
+	}
+	if w.validCoord(x-dx, y-dy) && w.GetTile(x-dx, y-dy).Walkable() {
+		back = field.Dist(x-dx, y-dy)
+	}
+	switch {
+	case !math.IsInf(forward, 1) && !math.IsInf(back, 1):
+		return (forward - back) / 2
+	case !math.IsInf(forward, 1):
+		return forward - d
+	case !math.IsInf(back, 1):
+		return d - back
+	}
+	return 0
 }
 
 func (w *State) validCoord(x, y int) bool {
 	return x >= 0 && y >= 0 && y < w.GetHeight() && x < w.GetWidth()
 }
 
-func (w *State) noWallsInCol(x, y1, y2 int) bool {
-
-	maxY := y1
-	minY := y2
-	if maxY < minY {
-		maxY = y2
-		minY = y1
-	}
-
-	for y := minY; y <= maxY; y++ {
-		if !w.validCoord(x, y) || !w.GetTile(x, y).Walkable() {
-			return false
-		}
-	}
-
-	return true
-}
-
 // Converts dX, dY to a direction:
 // flipping the y axis
 func deltaToDirection(dX, dY int) Direction {
@@ -222,17 +222,7 @@ func (p *ControlPanel) NewGenrateFlowFieldsButton() *Button {
 		pressed = true
 		log.Println("Generate Flow Fields")
 
-		InitFlowFields()
-		for _, dest := range p.world.scenario.Destinations {
-			log.Println("Flow field for", dest.Name, "starting")
-
-			err := p.world.GenerateFlowField(dest.ID)
-			log.Println("Flow field for", dest.Name, "done")
-			if err != nil {
-				log.Fatal("cannot make flow field for", dest)
-			}
-		}
-		log.Println("Flow fields done")
+		p.world.GenerateFlowFields()
 		return "Flow Fields Generated"
 
 	})
@@ -315,6 +305,9 @@ func (p *ControlPanel) NewExitButton() *Button {
 	return p.NewButton(fmt.Sprintf("Exit - click %d time(s)", clicks), icons.ActionExitToApp, false, func() string {
 		clicks--
 		if clicks < 1 {
+			p.world.StopRecording()
+			// deliver whatever is still queued before the sink is closed, as a headless run does
+			p.world.StopSenders()
 			os.Exit(0)
 		}
 
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCrowdingSpeed(t *testing.T) {
+	c := (&State{scenario: &Scenario{Crowding: &Crowding{}}}).crowding()
+	if c.Model != weidmannModel {
+		t.Fatalf("an empty crowding block gives the %s model, want %s", c.Model, weidmannModel)
+	}
+	if speed := c.speed(0); speed != 1 {
+		t.Errorf("speed in open space is %v, want 1", speed)
+	}
+	if speed := c.speed(c.JamDensity); speed != 0 {
+		t.Errorf("speed at the jam density is %v, want 0", speed)
+	}
+	if speed := c.speed(2 * c.JamDensity); speed != 0 {
+		t.Errorf("speed beyond the jam density is %v, want 0", speed)
+	}
+	// Weidmann's free walking speed of 1.34m/s falls to about 1.06m/s at 1 person per square metre
+	if speed := c.speed(1); math.Abs(speed*1.34-1.06) > 0.01 {
+		t.Errorf("speed at 1 person per square metre is %v of free flow, want about 0.79", speed)
+	}
+	last := 1.0
+	for density := 0.5; density < c.JamDensity; density += 0.5 {
+		speed := c.speed(density)
+		if speed >= last {
+			t.Errorf("speed at %v people per square metre is %v, not below %v", density, speed, last)
+		}
+		last = speed
+	}
+}
+
+func TestNoCrowdingByDefault(t *testing.T) {
+	s := &State{scenario: &Scenario{}, metresPerTile: 0.5}
+	if c := s.crowding(); c.Model != noCrowding {
+		t.Errorf("a scenario without a crowding block gives the %s model, want %s", c.Model, noCrowding)
+	}
+	if step := s.crowdedStep(&Individual{StepSize: 0.2}); step != 0.4 {
+		t.Errorf("step is %v tiles, want a full 0.4", step)
+	}
+}
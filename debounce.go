@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	rawStream       = "raw"
+	debouncedStream = "debounced"
+)
+
+// Debounce smooths out the enter and leave events of people loitering on the edge of a region, as apps do before
+// telling the backend. A region is only entered once someone has been in it for EnterDwell seconds and only left once
+// they have been out of it for LeaveGrace seconds, and while they are in it its edge is pushed out by Margin metres.
+// The scenario's settings apply to every region, and a region's own settings replace any of them they give.
+type Debounce struct {
+	EnterDwell float64 `json:"enterDwell,omitempty"` // seconds
+	LeaveGrace float64 `json:"leaveGrace,omitempty"` // seconds
+	Margin     float64 `json:"margin,omitempty"`     // metres
+	Send       string  `json:"send,omitempty"`       // which stream senders send, debounced (the default) or raw; scenario only
+}
+
+// debounceState is which regions an individual's debounced stream has them in, and since when the raw stream has
+// disagreed about each region it is waiting on
+type debounceState struct {
+	inside  map[int32]bool
+	pending map[int32]time.Time
+}
+
+func (s *State) debouncing() bool {
+	return s.scenario.Debounce != nil
+}
+
+// sendsDebounced is whether senders send the debounced stream rather than the raw one
+func (s *State) sendsDebounced() bool {
+	return s.debouncing() && s.scenario.Debounce.Send != rawStream
+}
+
+// sendsTruth is whether senders send the exact moment they enter and leave regions
+func (s *State) sendsTruth() bool {
+	return !s.sensing() && !s.sendsDebounced()
+}
+
+// debounceFor is the debounce settings of a region, with its margin in tiles
+func (s *State) debounceFor(r *Region) Debounce {
+	d := *s.scenario.Debounce
+	if r.Debounce != nil {
+		if r.Debounce.EnterDwell != 0 {
+			d.EnterDwell = r.Debounce.EnterDwell
+		}
+		if r.Debounce.LeaveGrace != 0 {
+			d.LeaveGrace = r.Debounce.LeaveGrace
+		}
+		if r.Debounce.Margin != 0 {
+			d.Margin = r.Debounce.Margin
+		}
+	}
+	d.Margin = s.metresToTiles(d.Margin)
+	return d
+}
+
+func (s *State) validateDebounce() []ValidationError {
+	var problems []ValidationError
+	check := func(path string, d *Debounce) {
+		if d.EnterDwell < 0 || d.LeaveGrace < 0 || d.Margin < 0 {
+			problems = append(problems, ValidationError{path, "enterDwell, leaveGrace and margin must not be negative"})
+		}
+	}
+	if d := s.scenario.Debounce; d != nil {
+		check("$.debounce", d)
+		if d.Send != "" && d.Send != rawStream && d.Send != debouncedStream {
+			problems = append(problems, ValidationError{"$.debounce.send", fmt.Sprintf("%q is not %s or %s", d.Send, debouncedStream, rawStream)})
+		}
+	}
+	for _, r := range s.Regions {
+		if r.Debounce == nil {
+			continue
+		}
+		if s.scenario.Debounce == nil {
+			problems = append(problems, ValidationError{"$.regions", fmt.Sprintf("region %d (%s) has debounce settings but the scenario has none", r.ID, r.Name)})
+		}
+		check(fmt.Sprintf("$.regions (region %d)", r.ID), r.Debounce)
+	}
+	return problems
+}
+
+func (i *Individual) debounceState() *debounceState {
+	if i.debounced == nil {
+		i.debounced = &debounceState{inside: make(map[int32]bool), pending: make(map[int32]time.Time)}
+	}
+	return i.debounced
+}
+
+// rawPosition is where the raw stream places the individual for a region, in tiles, and whether that position can
+// be used to test the region's margin
+func (s *State) rawPosition(p *Individual, r *Region) (float64, float64, bool) {
+	if s.sensing() {
+		st := p.phone(s)
+		switch {
+		case s.beaconSensed(r):
+			return r.X, r.Y, false
+		case s.gpsSensed(r):
+			return st.fixX, st.fixY, st.hasFix
+		}
+	}
+	x, y := p.Loc.GetLatestXY()
+	return x, y, true
+}
+
+// DebounceRegions follows the raw stream of every update sender, sending an update whenever the debounced stream
+// changes
+func (s *State) DebounceRegions() {
+	if !s.debouncing() {
+		return
+	}
+	for _, p := range s.allPeople {
+		if p.UpdateSender {
+			s.debounceIndividual(p)
+		}
+	}
+}
+
+func (s *State) debounceIndividual(p *Individual) {
+	d := p.debounceState()
+	// the raw stream is what the phone believes if there are sensors, else where they really are
+	raw := p.RegionIds
+	if s.sensing() {
+		raw = p.phone(s).inside
+	}
+	var ids []int
+	for id, in := range raw {
+		if in && !d.inside[id] {
+			ids = append(ids, int(id))
+		}
+	}
+	for id, in := range d.inside {
+		if in {
+			ids = append(ids, int(id))
+		}
+	}
+	for id := range d.pending {
+		if !raw[id] && !d.inside[id] {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		r := s.FindRegion(int32(id))
+		settings := s.debounceFor(r)
+		want := raw[r.ID]
+		x, y, placed := s.rawPosition(p, r)
+		if d.inside[r.ID] && !want && placed && settings.Margin > 0 {
+			want = r.Near(x, y, settings.Margin)
+		}
+		if want == d.inside[r.ID] {
+			delete(d.pending, r.ID)
+			continue
+		}
+		since, ok := d.pending[r.ID]
+		if !ok {
+			since = s.time
+			d.pending[r.ID] = since
+		}
+		wait := settings.LeaveGrace
+		if want {
+			wait = settings.EnterDwell
+		}
+		if s.time.Sub(since).Seconds() >= wait {
+			delete(d.pending, r.ID)
+			s.debouncedChange(p, r, want, x, y)
+		}
+	}
+}
+
+func (s *State) debouncedChange(p *Individual, r *Region, entering bool, x, y float64) {
+	p.debounceState().inside[r.ID] = entering
+	s.recordDebouncedEvent(p, r, entering)
+	if !s.sendsDebounced() {
+		return
+	}
+	u := update{EventID: r.EventID, RegionID: r.ID, UUID: p.UUID, Entering: entering, OccurredAt: s.time.Unix()}
+	if s.scenario.extendedUpdates() {
+		u.Lat, u.Lng, _ = s.TileToLatLng(x, y)
+	}
+	s.queueUpdate(p, u, s.BulkSend)
+}
+
+// leaveDebouncedRegions leaves every region the debounced stream has the individual in, without waiting, for when
+// they leave the site
+func (s *State) leaveDebouncedRegions(p *Individual) {
+	d := p.debounceState()
+	var ids []int
+	for id, in := range d.inside {
+		if in {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		r := s.FindRegion(int32(id))
+		x, y, _ := s.rawPosition(p, r)
+		s.debouncedChange(p, r, false, x, y)
+	}
+	d.pending = make(map[int32]time.Time)
+}
+
+// Near reports whether the point x, y is in the region or within margin tiles of its edge
+func (r *Region) Near(x, y, margin float64) bool {
+	if r.Contains(x, y) {
+		return true
+	}
+	if r.radius > 0 && math.Hypot(x-r.X, y-r.Y) < r.radius+margin {
+		return true
+	}
+	for _, p := range r.polygons {
+		for _, ring := range p {
+			for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+				if segmentDistance(x, y, ring[j][0], ring[j][1], ring[i][0], ring[i][1]) < margin {
+					return true
+				}
+			}
+		}
+	}
+	if r.area.Len() > 0 {
+		for tx := int(math.Floor(x - margin)); tx <= int(math.Floor(x+margin)); tx++ {
+			for ty := int(math.Floor(y - margin)); ty <= int(math.Floor(y+margin)); ty++ {
+				if !r.area.Contains(tx, ty) {
+					continue
+				}
+				// distance from the point to the nearest point of the tile
+				dx := math.Max(0, math.Max(float64(tx)-x, x-float64(tx+1)))
+				dy := math.Max(0, math.Max(float64(ty)-y, y-float64(ty+1)))
+				if math.Hypot(dx, dy) < margin {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// segmentDistance is the distance from the point px, py to the line segment from ax, ay to bx, by
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/l))
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
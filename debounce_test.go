@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+)
+
+func TestDebounceIndividual(t *testing.T) {
+	s := &State{
+		scenario:      &Scenario{Debounce: &Debounce{EnterDwell: 5, LeaveGrace: 3, Margin: 1, Send: rawStream}},
+		metresPerTile: 1,
+		Regions:       []Region{{ID: 1, X: 10, Y: 10, radius: 2, sqRad: 4}},
+		regionIndex:   map[int32]int{1: 0},
+		time:          time.Date(2018, 11, 23, 7, 0, 0, 0, time.UTC),
+	}
+	p := &Individual{RegionIds: make(map[int32]bool)}
+	// stand at x, 10 for the given number of ticks, checking the debounced stream after each
+	stand := func(x float64, ticks int, want []bool) {
+		t.Helper()
+		p.Loc = geometry.NewPoint(x, 10)
+		p.RegionIds[1] = s.Regions[0].Contains(x, 10)
+		for i := 0; i < ticks; i++ {
+			s.debounceIndividual(p)
+			if got := p.debounceState().inside[1]; got != want[i] {
+				t.Errorf("at %s standing at %g, inside is %t, want %t", s.time.Format("15:04:05"), x, got, want[i])
+			}
+			s.time = s.time.Add(time.Second)
+		}
+	}
+	// a brief visit isn't an enter
+	stand(10, 3, []bool{false, false, false})
+	stand(20, 2, []bool{false, false})
+	// staying for the dwell is
+	stand(10, 7, []bool{false, false, false, false, false, true, true})
+	// just outside the edge is still inside thanks to the margin
+	stand(12.5, 5, []bool{true, true, true, true, true})
+	// a brief step away isn't a leave
+	stand(20, 2, []bool{true, true})
+	stand(10, 2, []bool{true, true})
+	// being away for the grace is
+	stand(20, 5, []bool{true, true, true, false, false})
+}
@@ -6,7 +6,6 @@ import (
 	"math"
 
 	"github.com/jupp0r/go-priority-queue"
-	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
 )
 
 var deltas []pairInts
@@ -18,13 +17,19 @@ func initDeltas() {
 	deltas = append(deltas, pairInts{0, -1})
 	deltas = append(deltas, pairInts{1, 0})
 	deltas = append(deltas, pairInts{0, 1})
+	deltas = append(deltas, pairInts{-1, -1})
+	deltas = append(deltas, pairInts{1, -1})
+	deltas = append(deltas, pairInts{1, 1})
+	deltas = append(deltas, pairInts{-1, 1})
 
 }
 
-func FindShortestPath(w *State, destination DestinationID) error {
+// FindShortestPath fills in the distance from every tile to the destination. Each call uses its own queue and
+// field so it is safe to run for several destinations at once.
+func FindShortestPath(w *State, destination DestinationID, field *FlowField) error {
 
 	// Queue to hold fringe verticies
-	queue, _ := initQueue(w, destination)
+	queue, _ := initQueue(w, destination, field)
 
 	// While there are tiles in the queue
 	for queue.Len() > 0 {
@@ -42,11 +47,14 @@ func FindShortestPath(w *State, destination DestinationID) error {
 		}
 
 		// Relax each neighbouring tile
+		dist := field.Dist(tile.X, tile.Y)
 		neighbours := getValidNeighbouringTiles(tile, w)
 		for _, neighbour := range neighbours {
-			if (tile.Dists[destination] + 1) < neighbour.Dists[destination] {
-				neighbour.Dists[destination] = tile.Dists[destination] + 1
-				queue.UpdatePriority(neighbour, neighbour.Dists[destination])
+			i := field.index(neighbour.X, neighbour.Y)
+			newDist := dist + stepCost(tile, neighbour)
+			if newDist < field.Dists[i] {
+				field.Dists[i] = newDist
+				queue.UpdatePriority(neighbour, newDist)
 			}
 		}
 
@@ -58,7 +66,7 @@ func FindShortestPath(w *State, destination DestinationID) error {
 
 // Initialises queue, adding all nodes and setting distance to infinity
 // Nodes which we can reach
-func initQueue(w *State, destID DestinationID) (*pq.PriorityQueue, error) {
+func initQueue(w *State, destID DestinationID, field *FlowField) (*pq.PriorityQueue, error) {
 	dest := w.scenario.GetDestination(destID)
 	// Initialise the queue
 	queue := pq.New()
@@ -69,22 +77,14 @@ func initQueue(w *State, destID DestinationID) (*pq.PriorityQueue, error) {
 
 			tile := w.GetTile(i, j)
 
-			if tile.Dists == nil {
-				tile.Dists = make(map[DestinationID]float64)
-			}
-
-			// Set the direction for un-walkable tiles to be none
-			if !tile.Walkable() {
-				tile.Dists[destID] = math.Inf(1)
-			}
+			field.Dists[field.index(i, j)] = math.Inf(1)
 
 			// Skip the Destination and tiles we can't walk on
 			if !tile.Walkable() || (dest.ContainsCenter(i, j)) {
 				continue
 			}
 
-			tile.Dists[destID] = math.Inf(1)
-			queue.Insert(tile, tile.Dists[destID])
+			queue.Insert(tile, field.Dists[field.index(i, j)])
 
 		}
 
@@ -94,47 +94,62 @@ func initQueue(w *State, destID DestinationID) (*pq.PriorityQueue, error) {
 	for _, c := range dest.Coords {
 
 		destTile := w.GetTile(c.X, c.Y)
-		if destTile.Dists == nil {
-			destTile.Dists = make(map[DestinationID]float64)
-		}
-		if destTile.Directions == nil {
-			destTile.Directions = make(map[DestinationID]utils.OptionalFloat64)
+		field.Dists[field.index(c.X, c.Y)] = 0
+		queue.Insert(destTile, 0)
+	}
+	for _, p := range dest.area.Points() {
+		i := field.index(p.X, p.Y)
+		if field.Dists[i] == 0 {
+			continue
 		}
-		destTile.Dists[destID] = 0
-		queue.Insert(destTile, destTile.Dists[destID])
+		field.Dists[i] = 0
+		queue.Insert(w.GetTile(p.X, p.Y), 0)
 	}
 	return &queue, nil
 
 }
 
-func getValidNeighbouringTiles(t *Tile, w *State) []*Tile {
-	x := t.X
-	y := t.Y
+// stepCost is the distance walked between the centres of two neighbouring tiles, half of it across each tile and
+// weighted by its terrain cost
+func stepCost(from, to *Tile) float64 {
+	cost := (from.cost + to.cost) / 2
+	if from.X != to.X && from.Y != to.Y {
+		return math.Sqrt2 * cost
+	}
+	return cost
+}
 
-	tiles := make([]*Tile, 0)
+func getValidNeighbouringTiles(t *Tile, w *State) []*Tile {
+	tiles := make([]*Tile, 0, len(deltas))
 
 	for _, delta := range deltas {
-
-		if (t.blockedNorth && delta == pairInts{0, 1}) ||
-			(t.blockedEast && delta == pairInts{1, 0}) ||
-			(t.blockedSouth && delta == pairInts{0, -1}) ||
-			(t.blockedWest && delta == pairInts{-1, 0}) {
-			continue
-		}
-
-		newX := x + delta.fst
-		newY := y + delta.snd
-
-		if newX >= 0 && newX < w.GetWidth() &&
-			newY >= 0 && newY < w.GetHeight() {
-			tile := w.GetTile(newX, newY)
-			// Skip tiles we can't go to
-			if !tile.Walkable() {
-				continue
-			}
+		if tile := validStep(t, delta.fst, delta.snd, w); tile != nil {
 			tiles = append(tiles, tile)
 		}
 	}
 
 	return tiles
 }
+
+// validStep returns the tile reached by stepping dx, dy from t, or nil if that step is blocked. Diagonal steps are
+// only allowed when both of the orthogonal steps making them up are, so nobody cuts the corner of a wall.
+func validStep(t *Tile, dx, dy int, w *State) *Tile {
+	if (t.blockedNorth && dy == 1) ||
+		(t.blockedEast && dx == 1) ||
+		(t.blockedSouth && dy == -1) ||
+		(t.blockedWest && dx == -1) {
+		return nil
+	}
+
+	tile := w.GetTile(t.X+dx, t.Y+dy)
+	// Skip tiles we can't go to
+	if !tile.Walkable() {
+		return nil
+	}
+	if dx != 0 && dy != 0 {
+		if !w.GetTile(t.X+dx, t.Y).Walkable() || !w.GetTile(t.X, t.Y+dy).Walkable() {
+			return nil
+		}
+	}
+	return tile
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNoCornerCutting(t *testing.T) {
+	initDeltas()
+	w := gridWorld(
+		"...",
+		"#..",
+	)
+	if validStep(w.GetTile(1, 1), -1, -1, w) != nil {
+		t.Error("stepped diagonally past the corner of a wall")
+	}
+	if validStep(w.GetTile(2, 1), -1, -1, w) == nil {
+		t.Error("couldn't step diagonally across open floor")
+	}
+
+	field := w.computeFlowField(w.withDestination(0, 0))
+	for _, c := range []struct {
+		x, y int
+		want float64
+	}{
+		{1, 0, 1},
+		{1, 1, 2}, // round the corner, not across it
+		{2, 1, 1 + math.Sqrt2},
+	} {
+		if d := field.Dist(c.x, c.y); math.Abs(d-c.want) > 1e-9 {
+			t.Errorf("distance from (%d, %d) is %v, want %v", c.x, c.y, d, c.want)
+		}
+	}
+	if d := field.Dist(0, 1); !math.IsInf(d, 1) {
+		t.Errorf("distance from the wall is %v, want infinite", d)
+	}
+
+	// nobody is sent along a step that cuts the corner either
+	theta, ok := field.Direction(1, 1).Value()
+	if !ok {
+		t.Fatal("no direction at (1, 1)")
+	}
+	dx, dy := int(math.Round(math.Cos(theta))), int(math.Round(math.Sin(theta)))
+	if validStep(w.GetTile(1, 1), dx, dy, w) == nil {
+		t.Errorf("direction at (1, 1) steps %d, %d, into the wall", dx, dy)
+	}
+}
+
+func TestDiagonalDistances(t *testing.T) {
+	initDeltas()
+	w := gridWorld(
+		"....",
+		"....",
+		"....",
+		"....",
+	)
+	field := w.computeFlowField(w.withDestination(0, 0))
+	if d := field.Dist(3, 3); math.Abs(d-3*math.Sqrt2) > 1e-9 {
+		t.Errorf("distance across the diagonal is %v, want %v", d, 3*math.Sqrt2)
+	}
+	if d := field.Dist(3, 1); math.Abs(d-(2+math.Sqrt2)) > 1e-9 {
+		t.Errorf("distance to (3, 1) is %v, want %v", d, 2+math.Sqrt2)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
+)
+
+// flowFieldVersion is part of every cache key, bump it whenever the way flow fields are computed changes
+const flowFieldVersion = 2
+
+// FlowField holds the distance to one destination and the direction to walk in for every tile of the map
+type FlowField struct {
+	Width      int
+	Height     int
+	Dists      []float64
+	Directions []utils.OptionalFloat64
+}
+
+func NewFlowField(width, height int) *FlowField {
+	return &FlowField{
+		Width:      width,
+		Height:     height,
+		Dists:      make([]float64, width*height),
+		Directions: make([]utils.OptionalFloat64, width*height),
+	}
+}
+
+func (f *FlowField) index(x, y int) int {
+	return x*f.Height + y
+}
+
+func (f *FlowField) Dist(x, y int) float64 {
+	return f.Dists[f.index(x, y)]
+}
+
+func (f *FlowField) Direction(x, y int) utils.OptionalFloat64 {
+	return f.Directions[f.index(x, y)]
+}
+
+func (s *State) FlowField(dest DestinationID) *FlowField {
+	return s.flowFields[dest]
+}
+
+func (s *State) setFlowField(dest DestinationID, field *FlowField) {
+	if s.flowFields == nil {
+		s.flowFields = make(map[DestinationID]*FlowField)
+	}
+	s.flowFields[dest] = field
+}
+
+// GenerateFlowFields makes the flow field for every destination in parallel, reusing cached fields for any
+// destination whose map and coordinates have not changed since they were cached
+func (s *State) GenerateFlowFields() {
+	InitFlowFields()
+	var wg sync.WaitGroup
+	workers := make(chan bool, runtime.NumCPU())
+	fields := make([]*FlowField, len(s.scenario.Destinations))
+	for i := range s.scenario.Destinations {
+		i, dest := i, &s.scenario.Destinations[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- true
+			defer func() { <-workers }()
+
+			key := s.flowFieldKey(dest)
+			field, err := s.readCachedFlowField(key)
+			if err == nil {
+				log.Println("Flow field for", dest.Name, "loaded from cache")
+			} else {
+				log.Println("Flow field for", dest.Name, "starting")
+				field = s.computeFlowField(dest.ID)
+				log.Println("Flow field for", dest.Name, "done")
+				err = s.writeCachedFlowField(key, field)
+				if err != nil {
+					log.Println("cannot cache flow field for", dest.Name, err)
+				}
+			}
+			fields[i] = field
+		}()
+	}
+	wg.Wait()
+	for i, dest := range s.scenario.Destinations {
+		s.setFlowField(dest.ID, fields[i])
+	}
+	log.Println("Flow fields done")
+}
+
+// flowFieldKey hashes everything a destination's flow field depends on
+func (s *State) flowFieldKey(dest *Destination) string {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, int64(flowFieldVersion))
+	h.Write(s.mapHash)
+	for _, c := range dest.Coords {
+		binary.Write(h, binary.LittleEndian, []int64{int64(c.X), int64(c.Y)})
+		binary.Write(h, binary.LittleEndian, c.R)
+	}
+	for _, p := range dest.area.Points() {
+		binary.Write(h, binary.LittleEndian, []int64{int64(p.X), int64(p.Y)})
+	}
+	for _, t := range s.scenario.Terrain {
+		h.Write([]byte(t.Colour))
+		binary.Write(h, binary.LittleEndian, t.Cost)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *State) cacheDir() string {
+	if s.CacheDir != "" {
+		return s.CacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "rtfa-simulation", "flowfields")
+}
+
+func (s *State) readCachedFlowField(key string) (*FlowField, error) {
+	file, err := os.Open(filepath.Join(s.cacheDir(), key+".gob.gz"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedFlowField
+	err = gob.NewDecoder(reader).Decode(&cached)
+	if err != nil {
+		return nil, err
+	}
+	if cached.Width != s.width || cached.Height != s.height {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return cached.field(), nil
+}
+
+func (s *State) writeCachedFlowField(key string, field *FlowField) error {
+	err := os.MkdirAll(s.cacheDir(), 0777)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a half written field is never read back
+	file, err := ioutil.TempFile(s.cacheDir(), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	writer := gzip.NewWriter(file)
+	err = gob.NewEncoder(writer).Encode(newCachedFlowField(field))
+	if err == nil {
+		err = writer.Close()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(s.cacheDir(), key+".gob.gz"))
+}
+
+// cachedFlowField is the gob encoding of a FlowField, as utils.OptionalFloat64 has no exported fields.
+// Missing directions are stored as NaN.
+type cachedFlowField struct {
+	Width      int
+	Height     int
+	Dists      []float64
+	Directions []float64
+}
+
+func newCachedFlowField(f *FlowField) cachedFlowField {
+	c := cachedFlowField{Width: f.Width, Height: f.Height, Dists: f.Dists, Directions: make([]float64, len(f.Directions))}
+	for i, d := range f.Directions {
+		v, ok := d.Value()
+		if !ok {
+			v = math.NaN()
+		}
+		c.Directions[i] = v
+	}
+	return c
+}
+
+func (c cachedFlowField) field() *FlowField {
+	f := &FlowField{Width: c.Width, Height: c.Height, Dists: c.Dists, Directions: make([]utils.OptionalFloat64, len(c.Directions))}
+	for i, v := range c.Directions {
+		if math.IsNaN(v) {
+			f.Directions[i] = utils.OptionalFloat64WithEmptyValue()
+		} else {
+			f.Directions[i] = utils.OptionalFloat64WithValue(v)
+		}
+	}
+	return f
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestFlowFieldKey(t *testing.T) {
+	w := gridWorld(
+		"....",
+		"....",
+	)
+	w.mapHash = []byte("map")
+	w.withDestination(0, 0)
+	dest := &w.scenario.Destinations[0]
+	key := w.flowFieldKey(dest)
+	if again := w.flowFieldKey(dest); again != key {
+		t.Fatal("the key changed without anything changing")
+	}
+	dest.Name = "renamed"
+	dest.MeanTime = 100
+	if w.flowFieldKey(dest) != key {
+		t.Error("the key depends on more than the map, coords, area and terrain")
+	}
+
+	changes := map[string]func(){
+		"map":     func() { w.mapHash = []byte("other map") },
+		"coords":  func() { dest.Coords[0].X = 1 },
+		"radius":  func() { dest.Coords[0].R = 2 },
+		"area":    func() { dest.area.add(image.Point{X: 3, Y: 1}) },
+		"terrain": func() { w.scenario.Terrain = []TerrainClass{{Colour: "#00c800", Cost: 3}} },
+	}
+	for name, change := range changes {
+		saved, savedDest, savedTerrain := w.mapHash, *dest, w.scenario.Terrain
+		dest.Coords = append([]Coord(nil), dest.Coords...)
+		change()
+		if w.flowFieldKey(dest) == key {
+			t.Errorf("changing the %s kept the key", name)
+		}
+		w.mapHash, *dest, w.scenario.Terrain = saved, savedDest, savedTerrain
+	}
+}
+
+func TestFlowFieldCache(t *testing.T) {
+	initDeltas()
+	w := gridWorld(
+		"....",
+		"#...",
+	)
+	w.CacheDir = t.TempDir()
+	id := w.withDestination(0, 0)
+	field := w.computeFlowField(id)
+	if err := w.writeCachedFlowField("key", field); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := w.readCachedFlowField("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < w.width; x++ {
+		for y := 0; y < w.height; y++ {
+			if cached.Dist(x, y) != field.Dist(x, y) && !(math.IsInf(cached.Dist(x, y), 1) && math.IsInf(field.Dist(x, y), 1)) {
+				t.Errorf("distance at (%d, %d) read back as %v, was %v", x, y, cached.Dist(x, y), field.Dist(x, y))
+			}
+			if cached.Direction(x, y) != field.Direction(x, y) {
+				t.Errorf("direction at (%d, %d) read back as %v, was %v", x, y, cached.Direction(x, y), field.Direction(x, y))
+			}
+		}
+	}
+
+	// a field for a map of another size is never used
+	other := gridWorld("..", "..")
+	other.CacheDir = w.CacheDir
+	if _, err := other.readCachedFlowField("key"); err == nil {
+		t.Error("read back a field cached for a different sized map")
+	}
+	if _, err := w.readCachedFlowField("missing"); err == nil {
+		t.Error("read back a field never cached")
+	}
+}
+
+func TestGenerateFlowFieldsUsesCache(t *testing.T) {
+	initDeltas()
+	w := gridWorld(
+		"....",
+		"....",
+	)
+	w.CacheDir = t.TempDir()
+	id := w.withDestination(0, 0)
+	// cache a field which can't have been computed, so it is clear where the generated one came from
+	dest := &w.scenario.Destinations[0]
+	marked := w.computeFlowField(id)
+	marked.Dists[marked.index(3, 1)] = 42
+	if err := w.writeCachedFlowField(w.flowFieldKey(dest), marked); err != nil {
+		t.Fatal(err)
+	}
+	w.GenerateFlowFields()
+	if d := w.FlowField(id).Dist(3, 1); d != 42 {
+		t.Errorf("distance is %v, want the cached 42", d)
+	}
+
+	// moving the destination computes it afresh
+	dest.Coords[0].X = 1
+	w.GenerateFlowFields()
+	if d := w.FlowField(id).Dist(3, 1); d == 42 {
+		t.Error("the cached field was used for a destination which has moved")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Georeference places the map on the earth, either by the latitude and longitude of its outer corners or by an
+// affine world file for the image. TopRight is only needed if the map is not drawn with north up.
+type Georeference struct {
+	TopLeft     *LatLng `json:"topLeft,omitempty"`
+	TopRight    *LatLng `json:"topRight,omitempty"`
+	BottomRight *LatLng `json:"bottomRight,omitempty"`
+	WorldFile   string  `json:"worldFile,omitempty"` // e.g. map.pgw, in degrees of longitude and latitude
+}
+
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// geoTransform is the affine map from a position in tiles to longitude and latitude:
+// lng = lng0 + a*x + b*y, lat = lat0 + d*x + e*y
+type geoTransform struct {
+	lng0, a, b float64
+	lat0, d, e float64
+}
+
+func (g *geoTransform) toLatLng(x, y float64) (float64, float64) {
+	return g.lat0 + g.d*x + g.e*y, g.lng0 + g.a*x + g.b*y
+}
+
+func (g *geoTransform) toTile(lat, lng float64) (float64, float64) {
+	det := g.a*g.e - g.b*g.d
+	dlng, dlat := lng-g.lng0, lat-g.lat0
+	return (g.e*dlng - g.b*dlat) / det, (g.a*dlat - g.d*dlng) / det
+}
+
+// metresPerTile is the mean length of the sides of a tile in the middle of a width by height map
+func (g *geoTransform) metresPerTile(width, height int) float64 {
+	lat, _ := g.toLatLng(float64(width)/2, float64(height)/2)
+	mLat, mLng := metresPerDegree(lat)
+	across := math.Hypot(g.a*mLng, g.d*mLat)
+	down := math.Hypot(g.b*mLng, g.e*mLat)
+	return (across + down) / 2
+}
+
+// metresPerDegree is the length of a degree of latitude and of longitude at a latitude, on the WGS 84 ellipsoid
+func metresPerDegree(lat float64) (float64, float64) {
+	phi := lat * math.Pi / 180
+	mLat := 111132.954 - 559.822*math.Cos(2*phi) + 1.175*math.Cos(4*phi)
+	mLng := 111412.84*math.Cos(phi) - 93.5*math.Cos(3*phi)
+	return mLat, mLng
+}
+
+func cornersTransform(tl, tr, br LatLng, width, height int) *geoTransform {
+	return &geoTransform{
+		lng0: tl.Lng, a: (tr.Lng - tl.Lng) / float64(width), b: (br.Lng - tr.Lng) / float64(height),
+		lat0: tl.Lat, d: (tr.Lat - tl.Lat) / float64(width), e: (br.Lat - tr.Lat) / float64(height),
+	}
+}
+
+// readWorldFile reads the six lines of an ESRI world file. They place the centre of the top left pixel, which is
+// moved back to its corner as tiles are measured from their corners.
+func readWorldFile(path string) (*geoTransform, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("world file %s has %d values, not 6", path, len(fields))
+	}
+	var v [6]float64
+	for i, f := range fields {
+		v[i], err = strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("world file %s: %s", path, err)
+		}
+	}
+	a, d, b, e, c, f := v[0], v[1], v[2], v[3], v[4], v[5]
+	return &geoTransform{lng0: c - (a+b)/2, a: a, b: b, lat0: f - (d+e)/2, d: d, e: e}, nil
+}
+
+// originTransform puts the top left corner of the map at lat, lng with north up and tiles metresPerTile across,
+// for scenarios which only give an origin
+func originTransform(lat, lng, metresPerTile float64) *geoTransform {
+	mLat, mLng := metresPerDegree(lat)
+	return &geoTransform{lng0: lng, a: metresPerTile / mLng, lat0: lat, e: -metresPerTile / mLat}
+}
+
+// setupGeoreference works out where the map is and how big its tiles are, from the scenario's georef, or its lat and
+// lng origin, and its metresPerTile. Without either tiles are a metre across.
+func (s *State) setupGeoreference() []ValidationError {
+	scenario := s.scenario
+	if scenario.MetresPerTile < 0 {
+		return []ValidationError{{"$.metresPerTile", "must be positive"}}
+	}
+	s.geo = nil
+	g := scenario.Georef
+	switch {
+	case g != nil && g.WorldFile != "":
+		geo, err := readWorldFile(g.WorldFile)
+		if err != nil {
+			return []ValidationError{{"$.georef.worldFile", err.Error()}}
+		}
+		s.geo = geo
+	case g != nil:
+		if g.TopLeft == nil || g.BottomRight == nil {
+			return []ValidationError{{"$.georef", "needs a worldFile, or topLeft and bottomRight corners"}}
+		}
+		tr := LatLng{Lat: g.TopLeft.Lat, Lng: g.BottomRight.Lng}
+		if g.TopRight != nil {
+			tr = *g.TopRight
+		}
+		s.geo = cornersTransform(*g.TopLeft, tr, *g.BottomRight, s.width, s.height)
+	}
+	if s.geo != nil && s.geo.a*s.geo.e-s.geo.b*s.geo.d == 0 {
+		s.geo = nil
+		return []ValidationError{{"$.georef", "the corners of the map must not be in a line"}}
+	}
+
+	s.metresPerTile = scenario.MetresPerTile
+	if s.metresPerTile == 0 {
+		s.metresPerTile = 1
+		if s.geo != nil {
+			s.metresPerTile = s.geo.metresPerTile(s.width, s.height)
+		}
+	}
+	if s.geo == nil && (scenario.Lat != 0 || scenario.Lng != 0) {
+		s.geo = originTransform(scenario.Lat, scenario.Lng, s.metresPerTile)
+	}
+	s.personRadius = PersonRadius / s.metresPerTile
+	if s.personRadius > 0.5 && scenario.People == nil {
+		return []ValidationError{{"$.metresPerTile", fmt.Sprintf("tiles are %.2fm across, people need them to be at least %.1fm",
+			s.metresPerTile, 2*PersonRadius)}}
+	}
+	return nil
+}
+
+// TileToLatLng is the latitude and longitude of a position on the map, measured in tiles from its top left corner
+func (s *State) TileToLatLng(x, y float64) (float64, float64, error) {
+	if s.geo == nil {
+		return 0, 0, notGeoreferencedError{}
+	}
+	lat, lng := s.geo.toLatLng(x, y)
+	return lat, lng, nil
+}
+
+// LatLngToTile is the position on the map, in tiles from its top left corner, of a latitude and longitude
+func (s *State) LatLngToTile(lat, lng float64) (float64, float64, error) {
+	if s.geo == nil {
+		return 0, 0, notGeoreferencedError{}
+	}
+	x, y := s.geo.toTile(lat, lng)
+	return x, y, nil
+}
+
+// metresToTiles converts a length in metres to one in tiles
+func (s *State) metresToTiles(m float64) float64 {
+	return m / s.metresPerTile
+}
+
+type notGeoreferencedError struct {
+}
+
+func (e notGeoreferencedError) Error() string {
+	return "the scenario has no georef, or lat and lng, to place the map"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeoTransformRoundTrip(t *testing.T) {
+	transforms := map[string]*geoTransform{
+		"origin": originTransform(51.501761, -0.174522, 1),
+		"rotated corners": cornersTransform(LatLng{51.502, -0.175}, LatLng{51.503, -0.170}, LatLng{51.499, -0.169},
+			400, 300),
+	}
+	for name, g := range transforms {
+		for _, p := range [][2]float64{{0, 0}, {400, 0}, {123.5, 77.25}, {400, 300}} {
+			lat, lng := g.toLatLng(p[0], p[1])
+			x, y := g.toTile(lat, lng)
+			if math.Abs(x-p[0]) > 1e-6 || math.Abs(y-p[1]) > 1e-6 {
+				t.Errorf("%s: %v went to %v, %v and back to %v, %v", name, p, lat, lng, x, y)
+			}
+		}
+	}
+}
+
+func TestOriginTransform(t *testing.T) {
+	g := originTransform(51.5, -0.17, 2)
+	lat, lng := g.toLatLng(0, 0)
+	if lat != 51.5 || lng != -0.17 {
+		t.Errorf("top left corner is at %v, %v, want 51.5, -0.17", lat, lng)
+	}
+	// north up: across the map is east, down the map is south
+	lat, lng = g.toLatLng(100, 0)
+	if lat != 51.5 || lng <= -0.17 {
+		t.Errorf("100 tiles across is at %v, %v, want due east", lat, lng)
+	}
+	lat, lng = g.toLatLng(0, 100)
+	if lat >= 51.5 || lng != -0.17 {
+		t.Errorf("100 tiles down is at %v, %v, want due south", lat, lng)
+	}
+	if m := g.metresPerTile(200, 200); math.Abs(m-2) > 0.01 {
+		t.Errorf("tiles are %vm across, want 2m", m)
+	}
+}
+
+func TestCornersTransform(t *testing.T) {
+	tl, tr, br := LatLng{51.502, -0.175}, LatLng{51.503, -0.170}, LatLng{51.499, -0.169}
+	g := cornersTransform(tl, tr, br, 400, 300)
+	for _, c := range []struct {
+		x, y float64
+		want LatLng
+	}{{0, 0, tl}, {400, 0, tr}, {400, 300, br}} {
+		lat, lng := g.toLatLng(c.x, c.y)
+		if math.Abs(lat-c.want.Lat) > 1e-9 || math.Abs(lng-c.want.Lng) > 1e-9 {
+			t.Errorf("corner %v, %v is at %v, %v, want %v", c.x, c.y, lat, lng, c.want)
+		}
+	}
+}
@@ -1,16 +1,199 @@
 package main
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
 )
 
 type Group struct {
 	Individuals []*Individual
+	ID          int           // numbered from 1 as groups arrive, 0 when people are put in groups at random
+	entrance    int           // where the group arrives
+	arriving    int           // members still to come through the entrance
+	dest        DestinationID // where the group was last heading
+}
+
+// Groups makes people arrive in groups of the given sizes, each group coming in together through one entrance and
+// keeping together on the way to each destination. Members more than Spread metres closer to the destination than
+// the group's slowest wait for them, unless it is crowded around them, when the group splits up rather than block the
+// way. Without Groups people arrive one by one and are put into one of the scenario's totalGroups at random.
+type Groups struct {
+	Sizes   []GroupSize `json:"sizes"`
+	Spread  float64     `json:"spread,omitempty"`  // metres, 3 by default
+	SplitAt float64     `json:"splitAt,omitempty"` // people per square metre, 2 by default
+}
+
+// GroupSize is how likely a group of Size people is, relative to the other sizes
+type GroupSize struct {
+	Size   int     `json:"size"`
+	Weight float64 `json:"weight"`
+}
+
+func (s *Scenario) validateGroups() []ValidationError {
+	var problems []ValidationError
+	g := s.Groups
+	if g == nil {
+		if s.TotalGroups <= 0 {
+			problems = append(problems, ValidationError{"$.totalGroups", "must be positive"})
+		}
+		return problems
+	}
+	total := 0.0
+	for i, size := range g.Sizes {
+		if size.Size < 1 {
+			problems = append(problems, ValidationError{fmt.Sprintf("$.groups.sizes[%d].size", i), "must be at least 1"})
+		}
+		if size.Weight < 0 {
+			problems = append(problems, ValidationError{fmt.Sprintf("$.groups.sizes[%d].weight", i), "must not be negative"})
+		}
+		total += size.Weight
+	}
+	if total <= 0 {
+		problems = append(problems, ValidationError{"$.groups.sizes", "at least one size must have a positive weight"})
+	}
+	if g.Spread < 0 {
+		problems = append(problems, ValidationError{"$.groups.spread", "must not be negative"})
+	}
+	if g.SplitAt < 0 {
+		problems = append(problems, ValidationError{"$.groups.splitAt", "must not be negative"})
+	}
+	return problems
+}
+
+// AddGroups brings in the members of arriving groups, starting new groups as each finishes arriving, until everyone
+// has arrived or someone doesn't fit
+func (w *State) AddGroups() {
+	for w.peopleAdded < w.scenario.TotalPeople {
+		g := w.arriving
+		if g == nil {
+			w.groupsStarted++
+			g = &Group{ID: w.groupsStarted, entrance: w.rand.Intn(len(w.scenario.Entrances))}
+			g.arriving = w.groupSize()
+			if remaining := w.scenario.TotalPeople - w.peopleAdded; g.arriving > remaining {
+				g.arriving = remaining
+			}
+			w.groups = append(w.groups, g)
+			w.arriving = g
+		}
+		// members share a personality so they tend to want to go to the same places
+		var likelihoods []Likelihood
+		if len(g.Individuals) > 0 {
+			likelihoods = g.Individuals[0].Likelihoods
+		}
+		p := w.addPerson(func() int { return g.entrance }, likelihoods)
+		if p == nil {
+			return
+		}
+		g.Individuals = append(g.Individuals, p)
+		g.arriving--
+		if g.arriving == 0 {
+			w.arriving = nil
+		}
+	}
+}
+
+// pruneGroups drops the groups everyone has arrived for and left, so they aren't moved every tick for the rest of
+// the run
+func (w *State) pruneGroups() {
+	kept := w.groups[:0]
+	for _, g := range w.groups {
+		if len(g.Individuals) > 0 || g.arriving > 0 {
+			kept = append(kept, g)
+		}
+	}
+	for i := len(kept); i < len(w.groups); i++ {
+		w.groups[i] = nil
+	}
+	w.groups = kept
 }
 
-func (g *Group) Next(channel chan map[*Individual]utils.OptionalFloat64, w *State) {
+func (w *State) groupSize() int {
+	total := 0.0
+	for _, size := range w.scenario.Groups.Sizes {
+		total += size.Weight
+	}
+	pick := w.rand.Float64() * total
+	for _, size := range w.scenario.Groups.Sizes {
+		pick -= size.Weight
+		if pick < 0 {
+			return size.Size
+		}
+	}
+	return w.scenario.Groups.Sizes[len(w.scenario.Groups.Sizes)-1].Size
+}
+
+// HoldGroupsTogether decides which members of each group wait for the rest this tick. It runs before the groups are
+// moved, as it looks at where everyone is.
+func (w *State) HoldGroupsTogether() {
+	groups := w.scenario.Groups
+	if groups == nil {
+		return
+	}
+	spread := groups.Spread
+	if spread == 0 {
+		spread = 3
+	}
+	spread = w.metresToTiles(spread)
+	splitAt := groups.SplitAt
+	if splitAt == 0 {
+		splitAt = 2
+	}
+
+	for _, g := range w.groups {
+		for _, p := range g.Individuals {
+			p.waiting = false
+		}
+		field := w.FlowField(g.dest)
+		if len(g.Individuals) < 2 || field == nil {
+			continue
+		}
+		// members with no known route have no distance, and neither hold the others up nor wait for them
+		dists := make([]float64, len(g.Individuals))
+		slowest := 0.0
+		for i, p := range g.Individuals {
+			dists[i] = math.Inf(1)
+			if tile := w.GetTileHighRes(p.Loc.GetXY()); tile != nil {
+				dists[i] = field.Dist(tile.X, tile.Y)
+			}
+			if !math.IsInf(dists[i], 0) {
+				slowest = math.Max(slowest, dists[i])
+			}
+		}
+		for i, p := range g.Individuals {
+			// people in a line keep to it
+			if !math.IsInf(dists[i], 0) && dists[i] < slowest-spread && !p.queued && w.densityAround(p) < splitAt {
+				p.waiting = true
+			}
+		}
+	}
+}
+
+// densityAround is the number of people per square metre in the tiles around someone
+func (w *State) densityAround(p *Individual) float64 {
+	x, y := p.Loc.GetXY()
+	count := 0
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			if tile := w.GetTile(int(x)+dx, int(y)+dy); tile != nil {
+				count += len(tile.People)
+			}
+		}
+	}
+	return float64(count) / (9 * w.metresPerTile * w.metresPerTile)
+}
+
+// Movement is the direction an individual wants to move in this tick
+type Movement struct {
+	Individual *Individual
+	Direction  utils.OptionalFloat64
+}
+
+func (g *Group) Next(channel chan []Movement, w *State) {
 
 	dests := make(map[DestinationID]int, 0)
+	order := make([]DestinationID, 0)
 	bestVal := 0
 
 	// Get each Destination that someone would like to go to, and tally them up
@@ -22,6 +205,7 @@ func (g *Group) Next(channel chan map[*Individual]utils.OptionalFloat64, w *Stat
 		if !ok {
 			valSet = 1
 			dests[dest] = valSet
+			order = append(order, dest)
 
 		} else {
 			valSet = val + 1
@@ -34,18 +218,24 @@ func (g *Group) Next(channel chan map[*Individual]utils.OptionalFloat64, w *Stat
 	}
 
 	var chosenDest DestinationID
-	// Return the Destination with the highest weight
-	for dest, val := range dests {
-		if val == bestVal {
+	// Return the Destination with the highest weight, ties going to whichever was voted for first
+	for _, dest := range order {
+		if dests[dest] == bestVal {
 			chosenDest = dest
+			break
 		}
 	}
+	g.dest = chosenDest
 
 	// Tell each person in the group where they need to go, and they will tell you which direction they need to go in
 
-	directions := make(map[*Individual]utils.OptionalFloat64, 0)
+	directions := make([]Movement, 0, len(g.Individuals))
 	for _, individual := range g.Individuals {
-		directions[individual] = individual.DirectionForDestination(chosenDest, w)
+		if individual.waiting {
+			directions = append(directions, Movement{individual, utils.OptionalFloat64WithEmptyValue()})
+			continue
+		}
+		directions = append(directions, Movement{individual, individual.DirectionForDestination(chosenDest, w)})
 	}
 	channel <- directions
 }
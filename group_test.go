@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestPruneGroups(t *testing.T) {
+	left := &Group{ID: 1}
+	walking := &Group{ID: 2, Individuals: []*Individual{{}}}
+	arriving := &Group{ID: 3, arriving: 2}
+	w := &State{groups: []*Group{left, walking, arriving}}
+	w.pruneGroups()
+	if len(w.groups) != 2 || w.groups[0] != walking || w.groups[1] != arriving {
+		t.Errorf("kept groups %v, want 2 and 3", w.groups)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+)
+
+// RunHeadless prepares flow fields and runs the simulation to the end of the scenario without opening any windows.
+func RunHeadless(w *State) {
+	done := w.drainStats()
+	defer close(done)
+	w.GenerateFlowFields()
+	simulate(w, nil)
+	log.Println("Simulation finished at", w.time)
+	w.StopSenders()
+}
+
+// drainStats consumes the ticker channels normally read by the ControlPanel so the simulation never blocks on them,
+// until the returned channel is closed. Senders report to it until they are stopped, so close it after StopSenders.
+func (s *State) drainStats() chan<- struct{} {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.peopleAddedChan:
+			case <-s.peopleCurrentChan:
+			case <-s.simulationTimeChan:
+			case <-s.currentSendersChan:
+			case <-s.totalSendsChan:
+			case <-networkStats.queuedUpdates:
+			case <-networkStats.runningUpdates:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return done
+}
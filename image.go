@@ -1,33 +1,45 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
 	"image"
 	"image/color"
 	"image/png"
 	_ "image/png"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 func LoadFromImage(path string) State {
-
-	reader, err := os.Open(path)
+	world, err := ReadImage(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer reader.Close()
-	i, _, err := image.Decode(reader)
+	return world
+}
+
+func ReadImage(path string) (State, error) {
+
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		return State{}, err
+	}
+	i, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return State{}, err
 	}
+	mapHash := sha256.Sum256(data)
 	width := i.Bounds().Dx()
 	height := i.Bounds().Dy()
 	fmt.Println("image size:", width, height)
-	world := State{width: width, height: height, background: i, tiles: make([][]Tile, width)}
+	world := State{width: width, height: height, background: i, tiles: make([][]Tile, width), mapHash: mapHash[:]}
 
 	for x := 0; x < world.width; x++ {
 		world.tiles[x] = make([]Tile, world.height)
@@ -44,7 +56,8 @@ func LoadFromImage(path string) State {
 			world.tiles[x][y].blockedEast = blockedEast(c)
 			world.tiles[x][y].blockedSouth = blockedSouth(c)
 			world.tiles[x][y].blockedWest = blockedWest(c)
-			world.tiles[x][y].destID = destID(c)
+			world.tiles[x][y].cost = 1
+			world.tiles[x][y].speed = 1
 			if world.tiles[x][y].blockedNorth || world.tiles[x][y].blockedEast || world.tiles[x][y].blockedSouth || world.tiles[x][y].blockedWest {
 				log.Println("BLOCKING")
 			}
@@ -52,12 +65,7 @@ func LoadFromImage(path string) State {
 	}
 	fmt.Println("tiles size", len(world.tiles), len(world.tiles[0]))
 
-	return world
-}
-
-func destID(c color.Color) uint32 {
-	_, g, _, _ := c.RGBA()
-	return g
+	return world, nil
 }
 
 func sameColour(a, b color.Color) bool {
@@ -91,10 +99,14 @@ func (e noFlowFieldsError) Error() string {
 	return "No FlowFileds found"
 }
 
+func (s *State) flowFieldDir() string {
+	return filepath.Join(s.OutputDir, "flowfields")
+}
+
 func (s *State) LoadFlowField(dest DestinationID) error {
 	destination := s.scenario.GetDestination(dest)
 	filename := strings.Replace(destination.Name, " ", "-", -1)
-	path := fmt.Sprintf("%s/flowfields/%s.png", s.ScenarioName, filename)
+	path := filepath.Join(s.flowFieldDir(), filename+".png")
 	file, err := os.Open(path)
 	if err != nil {
 		log.Println("Cannot open, ", path, err)
@@ -113,34 +125,33 @@ func (s *State) LoadFlowField(dest DestinationID) error {
 		return err
 	}
 
+	field := NewFlowField(s.GetWidth(), s.GetHeight())
 	for y := 0; y < s.GetHeight(); y++ {
 		for x := 0; x < s.GetWidth(); x++ {
-			tile := s.GetTile(x, y)
 			colour := newImage.At(x, y)
 			dir, des := decode(colour)
-			if tile.Directions == nil {
-				tile.Directions = make(map[DestinationID]utils.OptionalFloat64)
-			}
-			tile.Directions[dest] = dir
-			if tile.Dists == nil {
-				tile.Dists = make(map[DestinationID]float64)
-			}
-			tile.Dists[dest] = des
+			field.Directions[field.index(x, y)] = dir
+			field.Dists[field.index(x, y)] = des
 		}
 	}
+	s.setFlowField(dest, field)
 	return nil
 
 }
 
 func (s *State) SaveFlowField(dest DestinationID) error {
-	err := os.MkdirAll(fmt.Sprintf("%s/flowfields/", s.ScenarioName), 0777)
+	field := s.FlowField(dest)
+	if field == nil {
+		return noFlowFieldsError{}
+	}
+	err := os.MkdirAll(s.flowFieldDir(), 0777)
 	if err != nil {
 		log.Println("Cannot open or make directory, ", err)
 		return err
 	}
 	destination := s.scenario.GetDestination(dest)
 	filename := strings.Replace(destination.Name, " ", "-", -1)
-	file, err := os.OpenFile(fmt.Sprintf("%s/flowfields/%s.png", s.ScenarioName, filename), os.O_CREATE|os.O_RDWR, 0666)
+	file, err := os.OpenFile(filepath.Join(s.flowFieldDir(), filename+".png"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
 	if err != nil {
 		log.Println("Cannot open or make file, ", err)
 		return err
@@ -152,57 +163,35 @@ func (s *State) SaveFlowField(dest DestinationID) error {
 		}
 	}()
 	newImage := image.NewNRGBA(image.Rect(0, 0, s.GetWidth(), s.GetHeight()))
-	exists := false
-	for y := 0; y < s.GetHeight(); y++ {
-		for x := 0; x < s.GetWidth(); x++ {
-			tile := s.GetTile(x, y)
-			optionalDir, ok := tile.Directions[dest]
-			distance, ok2 := tile.Dists[dest]
-			if ok && ok2 {
-				colour := encode(optionalDir, distance)
-				newImage.Set(x, y, colour)
-				if !exists && tile.Directions != nil && tile.Dists != nil {
-					exists = true
-				}
-			}
-		}
-	}
-
-	if !exists {
-		return noFlowFieldsError{}
-	}
 
 	maxDirDiff := 0.0
 	maxDisDiff := 0.0
 	for y := 0; y < s.GetHeight(); y++ {
 		for x := 0; x < s.GetWidth(); x++ {
-			tile := s.GetTile(x, y)
-			optionalDir, ok := tile.Directions[dest]
-			distance, ok2 := tile.Dists[dest]
-			if ok && ok2 {
-				colour := encode(optionalDir, distance)
-				checkDir, checkDis := decode(colour)
-				d, p := optionalDir.Value()
-				d1, p1 := checkDir.Value()
-				if p {
-					if !p1 {
-						log.Println("image direction present error")
-					} else {
-						dirDiff := math.Abs(d - d1)
-						if dirDiff > math.Pi {
-							dirDiff = (2 * math.Pi) - dirDiff
-						}
-						if dirDiff > maxDirDiff {
-							maxDirDiff = dirDiff
-						}
-						disDiff := math.Abs(distance - checkDis)
-						if disDiff > maxDisDiff {
-							maxDisDiff = disDiff
-						}
+			optionalDir := field.Direction(x, y)
+			distance := field.Dist(x, y)
+			colour := encode(optionalDir, distance)
+			checkDir, checkDis := decode(colour)
+			d, p := optionalDir.Value()
+			d1, p1 := checkDir.Value()
+			if p {
+				if !p1 {
+					log.Println("image direction present error")
+				} else {
+					dirDiff := math.Abs(d - d1)
+					if dirDiff > math.Pi {
+						dirDiff = (2 * math.Pi) - dirDiff
+					}
+					if dirDiff > maxDirDiff {
+						maxDirDiff = dirDiff
+					}
+					disDiff := math.Abs(distance - checkDis)
+					if disDiff > maxDisDiff {
+						maxDisDiff = disDiff
 					}
 				}
-				newImage.Set(x, y, colour)
 			}
+			newImage.Set(x, y, colour)
 		}
 	}
 
@@ -20,7 +20,8 @@ type Individual struct {
 	Loc          geometry.Point // The point where this person current is stood
 	Tick         int            // The current tick, from a base of 0, to measure time
 	Likelihoods  []Likelihood   // The array of likelihoods for their preferences
-	StepSize     float64        // The average size step that the person will walk at (picked from a normal distribution)
+	StepSize     float64        // The average size step in metres that the person will walk at (picked from the scenario's People)
+	Persona      string         // the kind of person they are, if they are one of the scenario's personas
 	UpdateSender bool           // set if this AI is to send updates
 	RegionIds    map[int32]bool // map (set) containing keys of all regions the actor is in
 	UUID         string         // UUID of this actor for sending updates
@@ -32,6 +33,17 @@ type Individual struct {
 	target       *Destination // current target Destination
 	leaveTime    time.Time    // time to leave current place
 	UpdateChan   *UpdateChan
+	sequence     int64 // sequence number of the last extended update sent for this individual
+	sensors      *phoneState
+	debounced    *debounceState
+	queued       bool         // in the line for their target
+	queuePos     int          // place in the line, 0 at the front
+	admitted     bool         // let in from the line and not yet finished at their target
+	avoid        *Destination // not to be chosen next, having just given up on or been let in from its line
+	waiting      bool         // standing still this tick for the rest of their group to catch up
+	radius       float64      // in tiles
+	vx, vy       float64      // last step in tiles, kept by steering models with momentum
+	rand         *rand.Rand   // this individual's own random stream, so runs are reproducible whichever goroutine moves them
 }
 
 const (
@@ -58,7 +70,7 @@ func (i *Individual) Next(w *State) DestinationID {
 	}
 	dest := w.scenario.GetDestination(i.target.ID)
 	x, y := i.Loc.GetXY()
-	if dest.Contains(int(x), int(y)) {
+	if dest.Contains(int(x), int(y)) && (dest.queue == nil || i.admitted) {
 		// inside target
 		if i.leaveTime.IsZero() {
 			dest := w.scenario.GetDestination(i.target.ID)
@@ -70,7 +82,7 @@ func (i *Individual) Next(w *State) DestinationID {
 					i.leaveTime = event.End
 				}
 			} else {
-				seconds := time.Duration((rand.NormFloat64()*dest.VarTime)+dest.MeanTime) * time.Second
+				seconds := time.Duration((i.rand.NormFloat64()*dest.VarTime)+dest.MeanTime) * time.Second
 				i.leaveTime = w.time.Add(seconds)
 			}
 		}
@@ -78,6 +90,9 @@ func (i *Individual) Next(w *State) DestinationID {
 			return i.target.ID
 		} else {
 			i.leaveTime = time.Time{}
+			if dest.queue != nil {
+				i.avoid = dest
+			}
 			destID := i.requestedDestination(w)
 			i.target = w.scenario.GetDestination(destID)
 			return destID
@@ -100,7 +115,7 @@ func (a *Individual) requestedDestination(w *State) DestinationID {
 	probs := make([]ProbabilityPair, 0)
 	for _, likelihood := range a.Likelihoods {
 		dest := w.scenario.GetDestination(likelihood.Destination)
-		if !dest.isClosed() {
+		if !dest.isClosed() && dest != a.avoid {
 			prob := likelihood.ProbabilityAtTick(w.time)
 
 			prob /= dest.density
@@ -112,13 +127,20 @@ func (a *Individual) requestedDestination(w *State) DestinationID {
 		}
 	}
 
+	a.avoid = nil
+
+	// everywhere they'd go is closed, or is the line they just gave up on
+	if len(probs) == 0 {
+		return w.scenario.Exit.ID
+	}
+
 	// Normalise them
 	for i, prob := range probs {
 		probs[i].prob = prob.prob / sum
 	}
 
 	// Take a sample from them
-	randPick := rand.Float64()
+	randPick := a.rand.Float64()
 	var sumSoFar float64 = 0
 	for _, prob := range probs {
 		sumSoFar += prob.prob
@@ -133,26 +155,34 @@ func (a *Individual) requestedDestination(w *State) DestinationID {
 
 func (i *Individual) DirectionForDestination(dest DestinationID, w *State) utils.OptionalFloat64 {
 
+	// people in a line keep their place in it whatever the rest of their group does
+	if i.target != nil && i.target.queue != nil && (dest == i.target.ID || i.queued) {
+		if direction, ok := i.queueDirection(w); ok {
+			return direction
+		}
+		dest = i.target.ID
+	}
+
 	x, y := i.Loc.GetXY()
 	if i.target.ContainsR(int(x), int(y), 0.7) {
 		// inside the area
-		return utils.OptionalFloat64WithValue((rand.Float64() - 0.5) * 2 * math.Pi)
+		return utils.OptionalFloat64WithValue((i.rand.Float64() - 0.5) * 2 * math.Pi)
 	}
 	tile := w.GetTileHighRes(i.Loc.GetXY())
 	if tile == nil {
 		return utils.OptionalFloat64WithEmptyValue()
 	}
+	field := w.FlowField(dest)
 
 	var newOri float64
 
 	// Pick a random "sway" so they dont walk just in ordinal directions - more realistic
 
 	// TODO: Look for people in their ordinal direction and follow them
-	v, ok := tile.Directions[dest]
-	if !ok {
+	if field == nil {
 		newOri = i.dumbDirection(w, dest)
 	} else {
-		Ori, present := v.Value()
+		Ori, present := field.Direction(tile.X, tile.Y).Value()
 
 		if !present {
 			newOri = i.dumbDirection(w, dest)
@@ -161,7 +191,12 @@ func (i *Individual) DirectionForDestination(dest DestinationID, w *State) utils
 		}
 	}
 
-	newSway := (rand.Float64() - 0.5) * math.Pi / 2
+	if !w.steering.Sways() {
+		i.CurrentOri, i.CurrentSway = newOri, 0
+		return utils.OptionalFloat64WithValue(newOri)
+	}
+
+	newSway := (i.rand.Float64() - 0.5) * math.Pi / 2
 
 	if i.LastMoveDist < 0.05 {
 		newSway *= 2.5
@@ -181,10 +216,17 @@ func (i *Individual) DirectionForDestination(dest DestinationID, w *State) utils
 func (i *Individual) dumbDirection(w *State, dest DestinationID) float64 {
 	x, y := i.Loc.GetXY()
 	destination := w.scenario.GetDestination(dest)
-	r := rand.Intn(len(destination.Coords))
-	coord := destination.Coords[r]
-	dx := float64(coord.X) - x
-	dy := float64(coord.Y) - y
+	points := destination.area.Points()
+	r := i.rand.Intn(len(destination.Coords) + len(points))
+	var tx, ty float64
+	if r < len(destination.Coords) {
+		tx, ty = float64(destination.Coords[r].X), float64(destination.Coords[r].Y)
+	} else {
+		p := points[r-len(destination.Coords)]
+		tx, ty = float64(p.X)+0.5, float64(p.Y)+0.5
+	}
+	dx := tx - x
+	dy := ty - y
 	theta := math.Atan2(dy, dx)
 	return theta
 }
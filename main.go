@@ -3,78 +3,73 @@ package main
 import (
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"time"
 
 	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
-	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
-	"golang.org/x/exp/shiny/driver"
-	"golang.org/x/exp/shiny/screen"
 )
 
-func main() {
-	args := os.Args
-	if len(args) < 2 {
-		log.Fatalln("Scenario file required")
-	}
-	w := LoadScenario(args[1])
-	w.MakeChannes()
-	w.BulkSend = false
-	w.SendUpdates = false
-	w.maxSenders = 300
-	log.Println("loaded scenario")
-
-	/*fmt.Println("pressed size:", w.GetWidth(), w.GetHeight())
-	for y := 0; y < w.GetHeight(); y++ {
-		for x := 0; x < w.GetWidth(); x++ {
-			if w.GetTile(x, y).Walkable() {
-				fmt.Print(" ")
-			} else {
-				fmt.Print("#")
-			}
-		}
-		fmt.Println()
-	}
-	fmt.Println()*/
+const usage = `usage: rtfa-simulation <command> [flags] <file>
 
-	driver.Main(func(s screen.Screen) {
-		r := SetupRender(s, w.GetImage(), &w.Regions, &w)
-		defer r.Release()
+commands:
+  run <scenario.json>                   run a simulation
+  flowfields generate <scenario.json>   generate and save the flow fields for every destination
+  flowfields show <scenario.json>       print the flow field for one destination
+  validate <scenario.json>              check a scenario for mistakes
+  render <scenario.json>                draw the map, regions and destinations to a PNG
+  replay <trace.jsonl|updates.jsonl>    replay a recorded trace, or re-send recorded updates with -updates
 
-		go simulate(&w, &r)
-
-		for r.Step() {
-		}
-		fmt.Println("EOL")
-
-	})
-
-	fmt.Println("Bottom")
+Run "rtfa-simulation <command> -h" for the flags of each command.
+`
 
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "run":
+		runCommand(args)
+	case "flowfields":
+		flowFieldsCommand(args)
+	case "validate":
+		validateCommand(args)
+	case "render":
+		renderCommand(args)
+	case "replay":
+		replayCommand(args)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
 }
 
 func simulate(world *State, r *RenderState) {
 
 	//time.Sleep(5 * time.Second)
-	<-world.playPauseChan
+	if r != nil {
+		<-world.playPauseChan
+	}
 	log.Println("Simulation starting")
+	world.setupQueues()
 
 	steps := 0
 
-	world.groups = make([]*Group, world.scenario.TotalGroups)
-	for i, _ := range world.groups {
-		world.groups[i] = &Group{
-			Individuals: make([]*Individual, 0),
+	// with arriving groups, groups are made as they arrive
+	if world.scenario.Groups == nil {
+		world.groups = make([]*Group, world.scenario.TotalGroups)
+		for i, _ := range world.groups {
+			world.groups[i] = &Group{
+				Individuals: make([]*Individual, 0),
+			}
 		}
 	}
 
 	// Set up parallel processing channels
-	channels := make([]chan map[*Individual]utils.OptionalFloat64, 0)
-	for i := 0; i < len(world.groups); i++ {
-		channel := make(chan map[*Individual]utils.OptionalFloat64)
-		channels = append(channels, channel)
-	}
+	channels := make([]chan []Movement, 0)
 
 	var avg float64 = -1
 	for world.time.Before(world.scenario.End) {
@@ -82,14 +77,23 @@ func simulate(world *State, r *RenderState) {
 		world.CalcDensity()
 
 		// add more people until someone doesn't fit
-		for i := world.peopleAdded; i < world.scenario.TotalPeople; i++ {
-			indiv := world.AddRandom()
-			if indiv == nil {
-				break
+		if world.scenario.Groups != nil {
+			world.AddGroups()
+			world.pruneGroups()
+		} else {
+			for i := world.peopleAdded; i < world.scenario.TotalPeople; i++ {
+				indiv := world.AddRandom()
+				if indiv == nil {
+					break
+				}
+				groupIndex := world.rand.Intn(world.scenario.TotalGroups)
+				world.groups[groupIndex].Individuals = append(world.groups[groupIndex].Individuals, indiv)
 			}
-			groupIndex := rand.Intn(world.scenario.TotalGroups)
-			world.groups[groupIndex].Individuals = append(world.groups[groupIndex].Individuals, indiv)
 		}
+		for len(channels) < len(world.groups) {
+			channels = append(channels, make(chan []Movement))
+		}
+		world.HoldGroupsTogether()
 
 		//fmt.Println(steps, "Tick at", t)
 
@@ -101,13 +105,19 @@ func simulate(world *State, r *RenderState) {
 		}
 
 		// Process each group as they come
-		for _, channel := range channels {
+		for _, channel := range channels[:len(world.groups)] {
 			// Process them as they come in
 			result := <-channel
 			processMovementsForGroup(world, result)
 		}
+		world.UpdateQueues()
 
-		r.SendEvent(UpdateEvent{World: world})
+		world.SenseRegions()
+		world.DebounceRegions()
+		world.recordTick()
+		if r != nil {
+			r.SendEvent(UpdateEvent{World: world})
+		}
 		world.peopleAddedChan <- world.peopleAdded
 		world.peopleCurrentChan <- world.peopleCurrent
 		world.simulationTimeChan <- world.time
@@ -132,6 +142,10 @@ func simulate(world *State, r *RenderState) {
 			SendBulk()
 		}
 
+		if world.Speed > 0 {
+			time.Sleep(time.Duration(float64(time.Second)/world.Speed) - time.Since(t))
+		}
+
 		select {
 		case <-world.playPauseChan:
 			<-world.playPauseChan
@@ -140,19 +154,23 @@ func simulate(world *State, r *RenderState) {
 
 	}
 
+	world.logQueues()
+	world.StopRecording()
 	fmt.Println("Ticker stopped")
 }
 
-func processMovementsForGroup(world *State, movements map[*Individual]utils.OptionalFloat64) {
-	for individual, direction := range movements {
-		theta, ok := direction.Value()
+func processMovementsForGroup(world *State, movements []Movement) {
+	for _, movement := range movements {
+		individual := movement.Individual
+		theta, ok := movement.Direction.Value()
 		if !ok {
 			// If we aren't meant to move... don't
 			world.MoveIndividual(individual, 0, 0)
+			individual.vx, individual.vy = 0, 0
 			continue
 		}
 
-		world.MoveIndividual(individual, theta, individual.StepSize)
+		world.steering.Move(world, individual, theta, world.crowdedStep(individual))
 
 		UpdateRegions(world, individual, world.time, world.BulkSend)
 
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/mockbackend"
+)
+
+// StartMockBackend runs an in-process mock backend and returns an http sink pointing at it. Every update the
+// simulation produces is kept so CheckMockBackend can compare the two afterwards.
+func (s *State) StartMockBackend() (*mockbackend.Server, UpdateSink) {
+	server := mockbackend.NewServer()
+	url, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		log.Fatalln("cannot start mock backend:", err)
+	}
+	log.Println("mock backend listening on", url)
+	produced := make([]update, 0)
+	s.produced = &produced
+	return server, NewHTTPSink(SinkConfig{Type: "http", URL: url})
+}
+
+// CheckMockBackend reports whether the mock backend saw exactly the enters and leaves the simulation produced.
+// It should only be called once the senders have been stopped.
+func (s *State) CheckMockBackend(server *mockbackend.Server) bool {
+	expected := make([]mockbackend.Update, len(*s.produced))
+	for i, u := range *s.produced {
+		expected[i] = mockbackend.Update(u)
+	}
+	diffs := mockbackend.Diff(expected, server.Updates())
+	problems := server.Problems()
+	log.Println("mock backend received", len(server.Updates()), "of", len(expected), "updates")
+	log.Println("mock backend occupancy:", server.Occupancy())
+	for _, d := range diffs {
+		log.Println("mock backend difference:", d)
+	}
+	for _, p := range problems {
+		log.Println("mock backend problem:", p)
+	}
+	return len(diffs) == 0 && len(problems) == 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const mockTestScenario = `{
+	"map": "WinterWonderland.png",
+	"regions": "WinterWonderland_Regions.json",
+	"lat": 51.501761,
+	"lng": -0.174522,
+	"start": "2018-11-23T07:00:00.000Z",
+	"end": "2018-11-23T07:10:00.000Z",
+	"entrance": [{"x": 57, "y": 44, "r": 0.1}, {"x": 293, "y": 36, "r": 0.1}],
+	"totalPeople": 40,
+	"totalGroups": 30,
+	"exit": {"coords": [{"x": 263, "y": 236, "r": 2}, {"x": 260, "y": 35, "r": 2}], "name": "exit gate",
+		"events": [{"name": "Home Time", "start": "2018-11-23T07:05:00.000Z", "end": "2018-11-23T07:10:00.000Z", "popularity": 0.5}]},
+	"destinations": [
+		{"regionId": 107, "meanUseTime": 60, "useTimeVar": 10, "name": "Ice Bar",
+			"events": [{"name": "General Drinks", "start": "2018-11-23T07:00:00.000Z", "end": "2018-11-23T07:10:00.000Z", "popularity": 0.5}]},
+		{"regionId": 110, "meanUseTime": 60, "useTimeVar": 10, "name": "Ice Rink",
+			"events": [{"name": "General Use", "start": "2018-11-23T07:00:00.000Z", "end": "2018-11-23T07:10:00.000Z", "popularity": 0.5}]}
+	]
+}`
+
+func TestMockBackendSeesEverySend(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scenario.json")
+	if err := ioutil.WriteFile(path, []byte(mockTestScenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, problems := ReadScenario(path)
+	if len(problems) > 0 {
+		t.Fatalf("scenario problems: %v", problems)
+	}
+	w.MakeChannes()
+	w.CacheDir = dir
+	w.OutputDir = dir
+	w.SetSeed(3)
+	w.maxSenders = 300
+
+	backend, sink := w.StartMockBackend()
+	w.StartSenders(sink)
+	RunHeadless(w)
+
+	if len(backend.Updates()) == 0 {
+		t.Fatal("mock backend received nothing")
+	}
+	if !w.CheckMockBackend(backend) {
+		t.Error("mock backend disagrees with the simulation, see the log")
+	}
+}
@@ -0,0 +1,299 @@
+// Package mockbackend is a stand-in for the rtfa backend which accepts the same /update and /bulkUpdate payloads,
+// keeps per-region occupancy and remembers everything it received so runs can be checked offline.
+package mockbackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Update is an enter or leave in either schema, the fields after OccurredAt are zero for legacy updates
+type Update struct {
+	UUID       string  `json:"uuid"`
+	EventID    int32   `json:"eventId"`
+	RegionID   int32   `json:"regionId"`
+	Entering   bool    `json:"entering"`
+	OccurredAt int64   `json:"occurredAt"`
+	Lat        float64 `json:"lat,omitempty"`
+	Lng        float64 `json:"lng,omitempty"`
+	Accuracy   float64 `json:"accuracy,omitempty"`
+	RegionType string  `json:"regionType,omitempty"`
+	Sequence   int64   `json:"sequence,omitempty"`
+}
+
+// rawUpdate uses pointers so missing fields can be told apart from zero values
+type rawUpdate struct {
+	UUID       *string  `json:"uuid"`
+	EventID    *int32   `json:"eventId"`
+	RegionID   *int32   `json:"regionId"`
+	Entering   *bool    `json:"entering"`
+	OccurredAt *int64   `json:"occurredAt"`
+	Lat        *float64 `json:"lat"`
+	Lng        *float64 `json:"lng"`
+	Accuracy   *float64 `json:"accuracy"`
+	RegionType *string  `json:"regionType"`
+	Sequence   *int64   `json:"sequence"`
+}
+
+type presence struct {
+	uuid   string
+	region int32
+}
+
+type Server struct {
+	lock      sync.Mutex
+	mux       *http.ServeMux
+	updates   []Update
+	occupancy map[int32]int
+	inside    map[presence]bool
+	sequences map[string]int64
+	problems  []string
+	requests  int
+}
+
+func NewServer() *Server {
+	s := &Server{mux: http.NewServeMux()}
+	s.Reset()
+	s.mux.HandleFunc("/update", s.handleUpdate)
+	s.mux.HandleFunc("/bulkUpdate", s.handleBulkUpdate)
+	s.mux.HandleFunc("/occupancy", s.handleOccupancy)
+	s.mux.HandleFunc("/received", s.handleReceived)
+	return s
+}
+
+// Listen serves the mock backend on addr in the background and returns the base URL it can be reached at
+func (s *Server) Listen(addr string) (string, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		err := http.Serve(listener, s)
+		if err != nil {
+			log.Println("mock backend stopped:", err)
+		}
+	}()
+	return "http://" + listener.Addr().String(), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var raw rawUpdate
+	if err := decodeStrict(r.Body, &raw); err != nil {
+		s.reject(w, "/update: "+err.Error())
+		return
+	}
+	u, err := raw.validate()
+	if err != nil {
+		s.reject(w, "/update: "+err.Error())
+		return
+	}
+	s.lock.Lock()
+	s.requests++
+	s.apply(u)
+	s.lock.Unlock()
+}
+
+func (s *Server) handleBulkUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var raws []rawUpdate
+	if err := decodeStrict(r.Body, &raws); err != nil {
+		s.reject(w, "/bulkUpdate: "+err.Error())
+		return
+	}
+	updates := make([]Update, 0, len(raws))
+	for i, raw := range raws {
+		u, err := raw.validate()
+		if err != nil {
+			s.reject(w, fmt.Sprintf("/bulkUpdate[%d]: %s", i, err))
+			return
+		}
+		updates = append(updates, u)
+	}
+	s.lock.Lock()
+	s.requests++
+	for _, u := range updates {
+		s.apply(u)
+	}
+	s.lock.Unlock()
+}
+
+func (s *Server) handleOccupancy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Occupancy())
+}
+
+func (s *Server) handleReceived(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Updates())
+}
+
+func (s *Server) reject(w http.ResponseWriter, problem string) {
+	s.lock.Lock()
+	s.problems = append(s.problems, problem)
+	s.lock.Unlock()
+	http.Error(w, problem, http.StatusBadRequest)
+}
+
+// apply must be called with the lock held
+func (s *Server) apply(u Update) {
+	s.updates = append(s.updates, u)
+	if u.Sequence != 0 {
+		if last := s.sequences[u.UUID]; u.Sequence <= last {
+			s.problems = append(s.problems, fmt.Sprintf("%s sent sequence %d after %d", u.UUID, u.Sequence, last))
+		}
+		s.sequences[u.UUID] = u.Sequence
+	}
+	p := presence{u.UUID, u.RegionID}
+	if u.Entering {
+		if s.inside[p] {
+			s.problems = append(s.problems, fmt.Sprintf("%s entered region %d twice", u.UUID, u.RegionID))
+			return
+		}
+		s.inside[p] = true
+		s.occupancy[u.RegionID]++
+	} else {
+		if !s.inside[p] {
+			s.problems = append(s.problems, fmt.Sprintf("%s left region %d without entering", u.UUID, u.RegionID))
+			return
+		}
+		delete(s.inside, p)
+		s.occupancy[u.RegionID]--
+	}
+}
+
+// Updates returns every valid update received, in arrival order
+func (s *Server) Updates() []Update {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Update(nil), s.updates...)
+}
+
+// Occupancy returns the number of people currently in each region
+func (s *Server) Occupancy() map[int32]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	occupancy := make(map[int32]int, len(s.occupancy))
+	for k, v := range s.occupancy {
+		occupancy[k] = v
+	}
+	return occupancy
+}
+
+// Problems returns every malformed request and inconsistent enter/leave pair seen so far
+func (s *Server) Problems() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.problems...)
+}
+
+// Requests returns the number of accepted requests, bulk requests counting once
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.updates = nil
+	s.occupancy = make(map[int32]int)
+	s.inside = make(map[presence]bool)
+	s.sequences = make(map[string]int64)
+	s.problems = nil
+	s.requests = 0
+}
+
+// Diff compares the updates the backend should have seen with the ones it did, ignoring arrival order, and
+// describes every difference
+func Diff(expected, received []Update) []string {
+	counts := make(map[Update]int)
+	for _, u := range expected {
+		counts[u]++
+	}
+	for _, u := range received {
+		counts[u]--
+	}
+	var diffs []string
+	for u, c := range counts {
+		if c > 0 {
+			diffs = append(diffs, fmt.Sprintf("missing %dx %+v", c, u))
+		} else if c < 0 {
+			diffs = append(diffs, fmt.Sprintf("unexpected %dx %+v", -c, u))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+func (raw rawUpdate) validate() (Update, error) {
+	switch {
+	case raw.UUID == nil || *raw.UUID == "":
+		return Update{}, fmt.Errorf("missing uuid")
+	case raw.EventID == nil:
+		return Update{}, fmt.Errorf("missing eventId")
+	case raw.RegionID == nil:
+		return Update{}, fmt.Errorf("missing regionId")
+	case raw.Entering == nil:
+		return Update{}, fmt.Errorf("missing entering")
+	case raw.OccurredAt == nil || *raw.OccurredAt <= 0:
+		return Update{}, fmt.Errorf("missing occurredAt")
+	case (raw.Lat == nil) != (raw.Lng == nil):
+		return Update{}, fmt.Errorf("lat and lng must be sent together")
+	case raw.Lat != nil && (*raw.Lat < -90 || *raw.Lat > 90 || *raw.Lng < -180 || *raw.Lng > 180):
+		return Update{}, fmt.Errorf("%v, %v is not a latitude and longitude", *raw.Lat, *raw.Lng)
+	case raw.Accuracy != nil && *raw.Accuracy < 0:
+		return Update{}, fmt.Errorf("negative accuracy")
+	case raw.Sequence != nil && *raw.Sequence <= 0:
+		return Update{}, fmt.Errorf("sequence must be positive")
+	}
+	u := Update{
+		UUID:       *raw.UUID,
+		EventID:    *raw.EventID,
+		RegionID:   *raw.RegionID,
+		Entering:   *raw.Entering,
+		OccurredAt: *raw.OccurredAt,
+	}
+	if raw.Lat != nil {
+		u.Lat, u.Lng = *raw.Lat, *raw.Lng
+	}
+	if raw.Accuracy != nil {
+		u.Accuracy = *raw.Accuracy
+	}
+	if raw.RegionType != nil {
+		u.RegionType = *raw.RegionType
+	}
+	if raw.Sequence != nil {
+		u.Sequence = *raw.Sequence
+	}
+	return u, nil
+}
+
+func decodeStrict(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("cannot write response", err)
+	}
+}
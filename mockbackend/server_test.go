@@ -0,0 +1,106 @@
+package mockbackend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, s *Server, path, body string) int {
+	t.Helper()
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestStrictDecoding(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"unknown field", "/update", `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":5,"colour":"red"}`},
+		{"missing uuid", "/update", `{"eventId":1,"regionId":2,"entering":true,"occurredAt":5}`},
+		{"missing entering", "/update", `{"uuid":"a","eventId":1,"regionId":2,"occurredAt":5}`},
+		{"missing occurredAt", "/update", `{"uuid":"a","eventId":1,"regionId":2,"entering":true}`},
+		{"lat without lng", "/update", `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":5,"lat":51.5}`},
+		{"not json", "/update", `uuid=a`},
+		{"bulk not a list", "/bulkUpdate", `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":5}`},
+		{"bulk bad entry", "/bulkUpdate", `[{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":5},{"uuid":"b"}]`},
+	}
+	for _, test := range tests {
+		s := NewServer()
+		if code := post(t, s, test.path, test.body); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", test.name, code, http.StatusBadRequest)
+		}
+		if len(s.Problems()) != 1 {
+			t.Errorf("%s: got problems %q, want one", test.name, s.Problems())
+		}
+		if len(s.Updates()) != 0 || s.Requests() != 0 {
+			t.Errorf("%s: rejected request was kept", test.name)
+		}
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	s := NewServer()
+	post(t, s, "/update", `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":5}`)
+	post(t, s, "/bulkUpdate", `[
+		{"uuid":"b","eventId":1,"regionId":2,"entering":true,"occurredAt":6,"lat":51.5,"lng":0,"sequence":1},
+		{"uuid":"a","eventId":1,"regionId":3,"entering":true,"occurredAt":7},
+		{"uuid":"a","eventId":1,"regionId":2,"entering":false,"occurredAt":8}
+	]`)
+	if problems := s.Problems(); len(problems) != 0 {
+		t.Fatalf("unexpected problems %q", problems)
+	}
+	occupancy := s.Occupancy()
+	if occupancy[2] != 1 || occupancy[3] != 1 {
+		t.Errorf("got occupancy %v, want 1 in regions 2 and 3", occupancy)
+	}
+	if len(s.Updates()) != 4 {
+		t.Errorf("got %d updates, want 4", len(s.Updates()))
+	}
+	if s.Requests() != 2 {
+		t.Errorf("got %d requests, want 2", s.Requests())
+	}
+}
+
+func TestInconsistentUpdates(t *testing.T) {
+	s := NewServer()
+	post(t, s, "/update", `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":5}`)
+	post(t, s, "/update", `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":6}`)
+	post(t, s, "/update", `{"uuid":"b","eventId":1,"regionId":2,"entering":false,"occurredAt":7}`)
+	problems := s.Problems()
+	want := []string{"a entered region 2 twice", "b left region 2 without entering"}
+	if len(problems) != len(want) {
+		t.Fatalf("got problems %q, want %q", problems, want)
+	}
+	for i := range want {
+		if problems[i] != want[i] {
+			t.Errorf("got problem %q, want %q", problems[i], want[i])
+		}
+	}
+	if occupancy := s.Occupancy(); occupancy[2] != 1 {
+		t.Errorf("got occupancy %v, want 1 in region 2", occupancy)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := Update{UUID: "a", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 5}
+	b := Update{UUID: "b", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 6}
+	c := Update{UUID: "c", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 7}
+	if diffs := Diff([]Update{a, b, b}, []Update{b, a, b}); len(diffs) != 0 {
+		t.Errorf("got differences %q for the same updates in another order", diffs)
+	}
+	diffs := Diff([]Update{a, b, b}, []Update{b, c})
+	if len(diffs) != 3 {
+		t.Fatalf("got differences %q, want 3", diffs)
+	}
+	for i, prefix := range []string{"missing 1x", "missing 1x", "unexpected 1x"} {
+		if !strings.HasPrefix(diffs[i], prefix) {
+			t.Errorf("got difference %q, want it to start %q", diffs[i], prefix)
+		}
+	}
+}
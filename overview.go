@@ -0,0 +1,153 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+
+	draw2 "golang.org/x/image/draw"
+)
+
+const overviewScale = 4
+
+var (
+	overviewRegionColour   = color.NRGBA{R: 30, G: 90, B: 220, A: 110}
+	overviewDestColour     = color.NRGBA{R: 240, G: 150, B: 0, A: 200}
+	overviewEntranceColour = color.NRGBA{R: 0, G: 180, B: 0, A: 255}
+	overviewExitColour     = color.NRGBA{R: 220, G: 0, B: 0, A: 255}
+)
+
+// RenderOverview draws the map with its regions, destinations, entrances and exits into overview.png in the output
+// directory and returns the path written
+func (s *State) RenderOverview() (string, error) {
+	bounds := s.background.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*overviewScale, bounds.Dy()*overviewScale))
+	draw2.NearestNeighbor.Scale(img, img.Bounds(), s.background, bounds, draw2.Src, nil)
+
+	for _, r := range s.Regions {
+		fillCircle(img, r.X, r.Y, r.radius, overviewRegionColour)
+		fillArea(img, &r.area, overviewRegionColour)
+		fillPolygons(img, &r, overviewRegionColour)
+	}
+	for _, d := range s.scenario.Destinations {
+		c := overviewDestColour
+		if d.ID == s.scenario.Exit.ID {
+			c = overviewExitColour
+		}
+		strokeArea(img, &d.area, c)
+		for _, coord := range d.Coords {
+			strokeCircle(img, float64(coord.X)+0.5, float64(coord.Y)+0.5, coord.R, c)
+		}
+	}
+	for _, e := range s.scenario.Entrances {
+		fillCircle(img, float64(e.X)+0.5, float64(e.Y)+0.5, 1, overviewEntranceColour)
+	}
+
+	err := os.MkdirAll(s.OutputDir, 0777)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.OutputDir, "overview.png")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	err = png.Encode(file, img)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return path, err
+}
+
+// fillCircle blends c over every pixel within r tiles of the tile position x, y
+func fillCircle(img *image.RGBA, x, y, r float64, c color.NRGBA) {
+	forCirclePixels(img, x, y, r, func(px, py int, d float64) {
+		if d < r*overviewScale {
+			blend(img, px, py, c)
+		}
+	})
+}
+
+func strokeCircle(img *image.RGBA, x, y, r float64, c color.NRGBA) {
+	forCirclePixels(img, x, y, r, func(px, py int, d float64) {
+		if d < r*overviewScale && d >= r*overviewScale-2 {
+			blend(img, px, py, c)
+		}
+	})
+}
+
+// fillArea blends c over every tile of a
+func fillArea(img *image.RGBA, a *Area, c color.NRGBA) {
+	for _, p := range a.Points() {
+		for px := p.X * overviewScale; px < (p.X+1)*overviewScale; px++ {
+			for py := p.Y * overviewScale; py < (p.Y+1)*overviewScale; py++ {
+				blend(img, px, py, c)
+			}
+		}
+	}
+}
+
+// fillPolygons blends c over every pixel whose centre is inside one of r's polygons
+func fillPolygons(img *image.RGBA, r *Region, c color.NRGBA) {
+	if len(r.polygons) == 0 {
+		return
+	}
+	minX, minY, maxX, maxY := r.polygonBounds()
+	bounds := img.Bounds()
+	for px := int(math.Max(0, minX*overviewScale)); px < bounds.Max.X && float64(px) <= maxX*overviewScale; px++ {
+		for py := int(math.Max(0, minY*overviewScale)); py < bounds.Max.Y && float64(py) <= maxY*overviewScale; py++ {
+			if r.inPolygons((float64(px)+0.5)/overviewScale, (float64(py)+0.5)/overviewScale) {
+				blend(img, px, py, c)
+			}
+		}
+	}
+}
+
+// strokeArea outlines a, drawing the edges of its tiles which border tiles outside it
+func strokeArea(img *image.RGBA, a *Area, c color.NRGBA) {
+	for _, p := range a.Points() {
+		x0, y0 := p.X*overviewScale, p.Y*overviewScale
+		for i := 0; i < overviewScale; i++ {
+			if !a.Contains(p.X, p.Y-1) {
+				blend(img, x0+i, y0, c)
+			}
+			if !a.Contains(p.X, p.Y+1) {
+				blend(img, x0+i, y0+overviewScale-1, c)
+			}
+			if !a.Contains(p.X-1, p.Y) {
+				blend(img, x0, y0+i, c)
+			}
+			if !a.Contains(p.X+1, p.Y) {
+				blend(img, x0+overviewScale-1, y0+i, c)
+			}
+		}
+	}
+}
+
+func forCirclePixels(img *image.RGBA, x, y, r float64, f func(px, py int, d float64)) {
+	cx := x * overviewScale
+	cy := y * overviewScale
+	pr := r*overviewScale + 1
+	for px := int(cx - pr); px <= int(cx+pr); px++ {
+		for py := int(cy - pr); py <= int(cy+pr); py++ {
+			if !(image.Point{X: px, Y: py}).In(img.Bounds()) {
+				continue
+			}
+			dx := float64(px) + 0.5 - cx
+			dy := float64(py) + 0.5 - cy
+			f(px, py, math.Sqrt(dx*dx+dy*dy))
+		}
+	}
+}
+
+func blend(img *image.RGBA, px, py int, c color.NRGBA) {
+	old := img.RGBAAt(px, py)
+	a := uint32(c.A)
+	mix := func(o, n uint8) uint8 {
+		return uint8((uint32(o)*(255-a) + uint32(n)*a) / 255)
+	}
+	img.SetRGBA(px, py, color.RGBA{R: mix(old.R, c.R), G: mix(old.G, c.G), B: mix(old.B, c.B), A: 255})
+}
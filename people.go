@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// People makes each person's step size and size their own, drawn as they arrive. A share of the crowd can be
+// personas, such as wheelchair users, people pushing buggies and children, who have distributions of their own.
+// Without People everyone steps 0.2m a tick and is PersonRadius across.
+type People struct {
+	StepSize Distribution `json:"stepSize"` // metres a tick, 0.2 by default
+	Radius   Distribution `json:"radius"`   // metres, PersonRadius by default
+	Personas []Persona    `json:"personas,omitempty"`
+}
+
+// Persona is a kind of person making up Share of the crowd. The built in personas (see personaPresets) fill in any
+// distribution not given, others fall back to the crowd's.
+type Persona struct {
+	Name     string       `json:"name"`
+	Share    float64      `json:"share"` // fraction of people
+	StepSize Distribution `json:"stepSize"`
+	Radius   Distribution `json:"radius"`
+}
+
+// Distribution is a normal distribution kept within Min and Max, which are half and one and a half times the mean
+// if not given
+type Distribution struct {
+	Mean float64 `json:"mean,omitempty"`
+	SD   float64 `json:"sd,omitempty"`
+	Min  float64 `json:"min,omitempty"`
+	Max  float64 `json:"max,omitempty"`
+}
+
+var personaPresets = map[string]Persona{
+	"child":      {StepSize: Distribution{Mean: 0.16, SD: 0.02}, Radius: Distribution{Mean: 0.15, SD: 0.02}},
+	"buggy":      {StepSize: Distribution{Mean: 0.17, SD: 0.02}, Radius: Distribution{Mean: 0.35, SD: 0.03, Max: 0.45}},
+	"wheelchair": {StepSize: Distribution{Mean: 0.15, SD: 0.03}, Radius: Distribution{Mean: 0.4, SD: 0.03, Max: 0.45}},
+}
+
+// or is d with any unset mean, deviation and bounds taken from fallback
+func (d Distribution) or(fallback Distribution) Distribution {
+	if d.Mean == 0 {
+		d.Mean = fallback.Mean
+		if d.SD == 0 {
+			d.SD = fallback.SD
+		}
+		if d.Min == 0 {
+			d.Min = fallback.Min
+		}
+		if d.Max == 0 {
+			d.Max = fallback.Max
+		}
+	}
+	return d
+}
+
+func (d Distribution) bounds() (float64, float64) {
+	min, max := d.Min, d.Max
+	if min == 0 {
+		min = d.Mean / 2
+	}
+	if max == 0 {
+		max = d.Mean * 1.5
+	}
+	return min, max
+}
+
+// largest is the most that can be drawn
+func (d Distribution) largest() float64 {
+	min, max := d.bounds()
+	if d.SD == 0 {
+		return math.Max(min, math.Min(max, d.Mean))
+	}
+	return max
+}
+
+func (d Distribution) draw(r *rand.Rand) float64 {
+	min, max := d.bounds()
+	return math.Max(min, math.Min(max, d.Mean+d.SD*r.NormFloat64()))
+}
+
+// crowd is the distributions of people who aren't a persona
+func (p *People) crowd() Persona {
+	return Persona{
+		StepSize: p.StepSize.or(Distribution{Mean: 0.2}),
+		Radius:   p.Radius.or(Distribution{Mean: PersonRadius}),
+	}
+}
+
+// persona fills in any distribution the persona doesn't give
+func (p *People) persona(i int) Persona {
+	persona := p.Personas[i]
+	preset := personaPresets[persona.Name]
+	crowd := p.crowd()
+	persona.StepSize = persona.StepSize.or(preset.StepSize).or(crowd.StepSize)
+	persona.Radius = persona.Radius.or(preset.Radius).or(crowd.Radius)
+	return persona
+}
+
+func (s *State) validatePeople() []ValidationError {
+	var problems []ValidationError
+	people := s.scenario.People
+	if people == nil {
+		return problems
+	}
+	check := func(path string, d Distribution, maxAllowed float64) {
+		min, max := d.bounds()
+		if d.Mean < 0 || d.SD < 0 || d.Min < 0 || d.Max < 0 {
+			problems = append(problems, ValidationError{path, "mean, sd, min and max must not be negative"})
+		} else if min > max {
+			problems = append(problems, ValidationError{path, fmt.Sprintf("min %g is above max %g", min, max)})
+		} else if maxAllowed > 0 && d.largest() > maxAllowed {
+			problems = append(problems, ValidationError{path, fmt.Sprintf("people up to %gm across don't fit on %.2fm tiles",
+				2*d.largest(), s.metresPerTile)})
+		}
+	}
+	crowd := people.crowd()
+	check("$.people.stepSize", crowd.StepSize, 0)
+	check("$.people.radius", crowd.Radius, s.metresPerTile/2)
+	share := 0.0
+	for i := range people.Personas {
+		path := fmt.Sprintf("$.people.personas[%d]", i)
+		persona := people.persona(i)
+		if persona.Share < 0 {
+			problems = append(problems, ValidationError{path + ".share", "must not be negative"})
+		}
+		share += persona.Share
+		check(path+".stepSize", persona.StepSize, 0)
+		check(path+".radius", persona.Radius, s.metresPerTile/2)
+		if _, ok := personaPresets[persona.Name]; !ok && people.Personas[i].StepSize.Mean == 0 && people.Personas[i].Radius.Mean == 0 {
+			var names []string
+			for name := range personaPresets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			problems = append(problems, ValidationError{path, fmt.Sprintf("%q is not one of %s and gives no stepSize or radius",
+				persona.Name, strings.Join(names, ", "))})
+		}
+	}
+	if share > 1 {
+		problems = append(problems, ValidationError{"$.people.personas", fmt.Sprintf("shares add up to %g, more than everyone", share)})
+	}
+	return problems
+}
+
+// body is the persona, step size in metres and radius in tiles someone arrives with
+type body struct {
+	persona  string
+	stepSize float64
+	radius   float64
+}
+
+func (s *State) drawBody() body {
+	people := s.scenario.People
+	if people == nil {
+		return body{stepSize: 0.2, radius: s.personRadius}
+	}
+	persona := people.crowd()
+	pick := s.rand.Float64()
+	for i := range people.Personas {
+		pick -= people.Personas[i].Share
+		if pick < 0 {
+			persona = people.persona(i)
+			break
+		}
+	}
+	return body{
+		persona:  persona.Name,
+		stepSize: persona.StepSize.draw(s.rand),
+		radius:   s.metresToTiles(persona.Radius.draw(s.rand)),
+	}
+}
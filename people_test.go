@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDistributionDraw(t *testing.T) {
+	tests := []struct {
+		name     string
+		d        Distribution
+		min, max float64
+	}{
+		{"default bounds", Distribution{Mean: 0.2, SD: 1}, 0.1, 0.3},
+		{"given bounds", Distribution{Mean: 0.2, SD: 1, Min: 0.15, Max: 0.25}, 0.15, 0.25},
+		{"only max given", Distribution{Mean: 0.35, SD: 1, Max: 0.45}, 0.175, 0.45},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		// with a wide deviation most draws are cut off, so both bounds are hit
+		lowest, highest := math.Inf(1), math.Inf(-1)
+		for i := 0; i < 1000; i++ {
+			v := test.d.draw(r)
+			lowest, highest = math.Min(lowest, v), math.Max(highest, v)
+		}
+		if math.Abs(lowest-test.min) > 1e-9 || math.Abs(highest-test.max) > 1e-9 {
+			t.Errorf("%s: drew from %v to %v, want %v to %v", test.name, lowest, highest, test.min, test.max)
+		}
+		if math.Abs(test.d.largest()-test.max) > 1e-9 {
+			t.Errorf("%s: largest is %v, want %v", test.name, test.d.largest(), test.max)
+		}
+	}
+
+	fixed := Distribution{Mean: 0.2}
+	if v := fixed.draw(r); v != 0.2 {
+		t.Errorf("drew %v with no deviation, want the mean 0.2", v)
+	}
+	if fixed.largest() != 0.2 {
+		t.Errorf("largest is %v with no deviation, want the mean 0.2", fixed.largest())
+	}
+}
+
+func TestPersonaDefaults(t *testing.T) {
+	people := People{
+		StepSize: Distribution{Mean: 0.25, SD: 0.05},
+		Personas: []Persona{
+			{Name: "wheelchair", Share: 0.1},
+			{Name: "buggy", Share: 0.1, StepSize: Distribution{Mean: 0.1}},
+			{Name: "skater", Share: 0.1, StepSize: Distribution{Mean: 0.4}},
+		},
+	}
+	tests := []struct {
+		persona          int
+		stepSize, radius Distribution
+	}{
+		{0, personaPresets["wheelchair"].StepSize, personaPresets["wheelchair"].Radius},
+		{1, Distribution{Mean: 0.1}, personaPresets["buggy"].Radius},
+		{2, Distribution{Mean: 0.4}, Distribution{Mean: PersonRadius}},
+	}
+	for _, test := range tests {
+		p := people.persona(test.persona)
+		if p.StepSize != test.stepSize || p.Radius != test.radius {
+			t.Errorf("%s steps %+v and is %+v across, want %+v and %+v",
+				p.Name, p.StepSize, p.Radius, test.stepSize, test.radius)
+		}
+	}
+	if crowd := people.crowd(); crowd.StepSize != people.StepSize || crowd.Radius != (Distribution{Mean: PersonRadius}) {
+		t.Errorf("the crowd steps %+v and is %+v across", crowd.StepSize, crowd.Radius)
+	}
+}
+
+func TestDrawBody(t *testing.T) {
+	w := gridWorld("..")
+	w.metresPerTile = 0.5
+	w.personRadius = w.metresToTiles(PersonRadius)
+	w.rand = rand.New(rand.NewSource(1))
+	if b := w.drawBody(); b != (body{stepSize: 0.2, radius: 0.4}) {
+		t.Errorf("without people everyone is %+v, want a 0.2m step and 0.4 tiles across", b)
+	}
+
+	w.scenario.People = &People{Personas: []Persona{{Name: "wheelchair", Share: 0.5}}}
+	wheelchairs := 0
+	for i := 0; i < 1000; i++ {
+		b := w.drawBody()
+		switch b.persona {
+		case "wheelchair":
+			wheelchairs++
+			// half the 0.4m mean up to the preset's 0.45m, on half metre tiles
+			if b.radius < 0.4-1e-9 || b.radius > 0.9+1e-9 {
+				t.Fatalf("a wheelchair user is %v tiles across", b.radius)
+			}
+		case "":
+			if b.stepSize != 0.2 || b.radius != 0.4 {
+				t.Fatalf("someone in the crowd is %+v", b)
+			}
+		default:
+			t.Fatalf("drew persona %q", b.persona)
+		}
+	}
+	if wheelchairs < 450 || wheelchairs > 550 {
+		t.Errorf("%d of 1000 people use a wheelchair, want about half", wheelchairs)
+	}
+}
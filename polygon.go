@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Ring is a closed loop of [x, y] points, the last joined back to the first
+type Ring [][2]float64
+
+// Polygon is an outer ring followed by any holes cut out of it
+type Polygon []Ring
+
+// Contains uses the even-odd rule across every ring, so points in a hole are outside
+func (p Polygon) Contains(x, y float64) bool {
+	inside := false
+	for _, ring := range p {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			xi, yi := ring[i][0], ring[i][1]
+			xj, yj := ring[j][0], ring[j][1]
+			if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// centre is the centroid of the outer ring, or the mean of its points if it has no area
+func (p Polygon) centre() (float64, float64) {
+	ring := p[0]
+	var area, cx, cy, mx, my float64
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		cross := ring[j][0]*ring[i][1] - ring[i][0]*ring[j][1]
+		area += cross
+		cx += (ring[j][0] + ring[i][0]) * cross
+		cy += (ring[j][1] + ring[i][1]) * cross
+		mx += ring[i][0]
+		my += ring[i][1]
+	}
+	if area == 0 {
+		return mx / float64(len(ring)), my / float64(len(ring))
+	}
+	return cx / (3 * area), cy / (3 * area)
+}
+
+// shapes gathers a region's polygon and multipolygon together
+func (r *Region) shapes() []Polygon {
+	if len(r.Polygon) == 0 {
+		return r.MultiPolygon
+	}
+	return append([]Polygon{r.Polygon}, r.MultiPolygon...)
+}
+
+func (r *Region) inPolygons(x, y float64) bool {
+	for _, p := range r.polygons {
+		if p.Contains(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// polygonBounds is the box around the outer rings of a region's polygons
+func (r *Region) polygonBounds() (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, p := range r.polygons {
+		for _, pt := range p[0] {
+			minX, maxX = math.Min(minX, pt[0]), math.Max(maxX, pt[0])
+			minY, maxY = math.Min(minY, pt[1]), math.Max(maxY, pt[1])
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+// checkPolygons makes sure every ring of a region's polygons can enclose something
+func (r *Region) checkPolygons() error {
+	for i, p := range r.polygons {
+		if len(p) == 0 {
+			return fmt.Errorf("region %d (%s): polygon %d has no rings", r.ID, r.Name, i)
+		}
+		for j, ring := range p {
+			if len(ring) < 3 {
+				return fmt.Errorf("region %d (%s): ring %d of polygon %d has fewer than 3 points", r.ID, r.Name, j, i)
+			}
+		}
+	}
+	return nil
+}
+
+// regionTiles is every walkable tile inside a region's polygons or painted area; circles are left to the destination
+// coords made from them
+func (s *State) regionTiles(r *Region) *Area {
+	tiles := &Area{}
+	tiles.addArea(&r.area)
+	if len(r.polygons) == 0 {
+		return tiles
+	}
+	minX, minY, maxX, maxY := r.polygonBounds()
+	for x := int(math.Max(0, math.Floor(minX))); x < s.width && float64(x) <= maxX; x++ {
+		for y := int(math.Max(0, math.Floor(minY))); y < s.height && float64(y) <= maxY; y++ {
+			if s.tiles[x][y].walkable && r.inPolygons(float64(x)+0.5, float64(y)+0.5) {
+				tiles.add(image.Point{X: x, Y: y})
+			}
+		}
+	}
+	return tiles
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	ID         json.Number `json:"id"`
+	Properties Region      `json:"properties"`
+	Geometry   struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// isGeoJSON reports whether a regions file is a GeoJSON object rather than a list of regions
+func isGeoJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
+
+// parseGeoJSONRegions reads regions from a GeoJSON FeatureCollection of Points, Polygons and MultiPolygons, such as
+// one exported from QGIS. Feature properties are read like a region in a regions file, with the feature's id as the
+// region ID if the properties have none, and [lng, lat] positions are converted to tiles with toTile.
+func parseGeoJSONRegions(data []byte, toTile func(lat, lng float64) (float64, float64, error)) ([]Region, error) {
+	var collection geoJSONFeatureCollection
+	err := json.Unmarshal(data, &collection)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("GeoJSON regions must be a FeatureCollection, not a %s", collection.Type)
+	}
+	toPolygon := func(rings [][][]float64) (Polygon, error) {
+		p := make(Polygon, len(rings))
+		for i, ring := range rings {
+			for _, pos := range ring {
+				if len(pos) < 2 {
+					return nil, fmt.Errorf("position %v needs a longitude and latitude", pos)
+				}
+				x, y, err := toTile(pos[1], pos[0])
+				if err != nil {
+					return nil, err
+				}
+				p[i] = append(p[i], [2]float64{x, y})
+			}
+		}
+		return p, nil
+	}
+
+	regions := make([]Region, 0, len(collection.Features))
+	for i, f := range collection.Features {
+		r := f.Properties
+		if r.ID == 0 && f.ID != "" {
+			id, err := f.ID.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("feature %d: id %s is not a number", i, f.ID)
+			}
+			r.ID = int32(id)
+		}
+		switch f.Geometry.Type {
+		case "Point":
+			var pos []float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &pos)
+			if err == nil && len(pos) < 2 {
+				err = fmt.Errorf("position %v needs a longitude and latitude", pos)
+			}
+			if err == nil {
+				r.X, r.Y = 0, 0
+				r.Lat, r.Lng = pos[1], pos[0]
+			}
+		case "Polygon":
+			var rings [][][]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &rings)
+			if err == nil {
+				r.Polygon, err = toPolygon(rings)
+			}
+		case "MultiPolygon":
+			var polygons [][][][]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &polygons)
+			for _, rings := range polygons {
+				if err != nil {
+					break
+				}
+				var p Polygon
+				p, err = toPolygon(rings)
+				r.MultiPolygon = append(r.MultiPolygon, p)
+			}
+		default:
+			err = fmt.Errorf("%s geometry is not supported", f.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("feature %d (%s): %s", i, r.Name, err)
+		}
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestPolygonContains(t *testing.T) {
+	square := Ring{{0, 0}, {10, 0}, {10, 10}, {0, 10}}
+	hole := Ring{{4, 4}, {6, 4}, {6, 6}, {4, 6}}
+	tests := []struct {
+		name    string
+		polygon Polygon
+		x, y    float64
+		want    bool
+	}{
+		{"inside", Polygon{square}, 5, 5, true},
+		{"outside", Polygon{square}, 11, 5, false},
+		{"left of", Polygon{square}, -1, 5, false},
+		{"in the hole", Polygon{square, hole}, 5, 5, false},
+		{"around the hole", Polygon{square, hole}, 2, 5, true},
+		{"inside an L", Polygon{{{0, 0}, {10, 0}, {10, 4}, {4, 4}, {4, 10}, {0, 10}}}, 2, 8, true},
+		{"in the corner of an L", Polygon{{{0, 0}, {10, 0}, {10, 4}, {4, 4}, {4, 10}, {0, 10}}}, 8, 8, false},
+	}
+	for _, test := range tests {
+		if got := test.polygon.Contains(test.x, test.y); got != test.want {
+			t.Errorf("%s: Contains(%g, %g) = %t, want %t", test.name, test.x, test.y, got, test.want)
+		}
+	}
+}
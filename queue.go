@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
+)
+
+// queueJoinDistance is how close, in tiles, someone must get to the back of a line to join it, and queueApproach how
+// much further along the flow field than the back of the line they start walking straight to it
+const (
+	queueJoinDistance = 1.5
+	queueApproach     = 5.0
+
+	defaultServiceTime = 10.0 // seconds
+)
+
+// Queue is the line for a destination. People heading to the destination join the back of it, unless it is too long
+// and they balk, and may give up once they run out of patience. If the destination has servers or a service time,
+// each server serves the person at the front of the line, who is let in once served if the destination has room;
+// otherwise the person at the front is let in as soon as there is room. With a queue region the line forms
+// through it, else people wait where they are when they reach the destination.
+type Queue struct {
+	region      *Region       // nil if the line has no region
+	slots       []image.Point // walkable tiles of the region from the front of the line to the back
+	line        []*Individual
+	serving     []*Individual // being served, at the front of the line
+	admitted    []*Individual // let in and not yet finished at the destination
+	joined      map[*Individual]time.Time
+	giveUp      map[*Individual]time.Time // when people run out of patience, if they ever do
+	serviceEnds map[*Individual]time.Time
+	servedAll   int
+	balked      int
+	abandoned   int
+	totalWait   float64
+	longest     float64
+}
+
+func (d *Destination) hasQueue() bool {
+	return d.QueueRegionID != 0 || d.Capacity > 0 || d.Servers > 0
+}
+
+func (s *State) validateQueues() []ValidationError {
+	var problems []ValidationError
+	for i, d := range s.scenario.Destinations {
+		path := fmt.Sprintf("$.Destinations[%d]", i)
+		if d.Capacity < 0 || d.Servers < 0 || d.ServiceTime < 0 || d.ServiceTimeVar < 0 || d.BalkAt < 0 ||
+			d.Patience < 0 || d.PatienceVar < 0 {
+			problems = append(problems, ValidationError{path, "capacity, servers, service times, balkAt and patience must not be negative"})
+		}
+		if !d.hasQueue() && (d.BalkAt > 0 || d.Patience > 0) {
+			problems = append(problems, ValidationError{path, "balkAt and patience need a queueRegionId, capacity or servers"})
+		}
+		if d.QueueRegionID == 0 {
+			continue
+		}
+		r := s.FindRegion(d.QueueRegionID)
+		switch {
+		case r == nil:
+			problems = append(problems, ValidationError{path + ".queueRegionId", fmt.Sprintf("region %d is not in %s", d.QueueRegionID, s.scenario.RegionsFile)})
+		case !r.IsQueue:
+			problems = append(problems, ValidationError{path + ".queueRegionId", fmt.Sprintf("region %d (%s) is not a queue", r.ID, r.Name)})
+		case len(s.queueTiles(r, &s.scenario.Destinations[i])) == 0:
+			problems = append(problems, ValidationError{path + ".queueRegionId", fmt.Sprintf("region %d (%s) has no walkable tiles outside %s", r.ID, r.Name, d.Name)})
+		}
+	}
+	if s.scenario.Exit.hasQueue() {
+		problems = append(problems, ValidationError{"$.exit", "the exit can't have a queue, capacity or servers"})
+	}
+	return problems
+}
+
+// queueTiles is every walkable tile of a queue region which isn't part of its destination
+func (s *State) queueTiles(r *Region, d *Destination) []image.Point {
+	var tiles []image.Point
+	minX, minY, maxX, maxY := r.X-r.radius, r.Y-r.radius, r.X+r.radius, r.Y+r.radius
+	if len(r.polygons) > 0 {
+		pMinX, pMinY, pMaxX, pMaxY := r.polygonBounds()
+		minX, minY = math.Min(minX, pMinX), math.Min(minY, pMinY)
+		maxX, maxY = math.Max(maxX, pMaxX), math.Max(maxY, pMaxY)
+	}
+	for _, p := range r.area.Points() {
+		minX, minY = math.Min(minX, float64(p.X)), math.Min(minY, float64(p.Y))
+		maxX, maxY = math.Max(maxX, float64(p.X)), math.Max(maxY, float64(p.Y))
+	}
+	for x := int(math.Max(0, math.Floor(minX))); x < s.width && float64(x) <= maxX; x++ {
+		for y := int(math.Max(0, math.Floor(minY))); y < s.height && float64(y) <= maxY; y++ {
+			if s.tiles[x][y].walkable && r.Contains(float64(x)+0.5, float64(y)+0.5) && !d.Contains(x, y) {
+				tiles = append(tiles, image.Point{X: x, Y: y})
+			}
+		}
+	}
+	return tiles
+}
+
+// setupQueues gives every destination which needs one its line, ordered by how far each tile of its queue region is
+// from the destination. It needs the flow fields, falling back to straight line distances for any destination without
+// one.
+func (s *State) setupQueues() {
+	for i := range s.scenario.Destinations {
+		d := &s.scenario.Destinations[i]
+		if !d.hasQueue() {
+			continue
+		}
+		q := &Queue{
+			joined:      make(map[*Individual]time.Time),
+			giveUp:      make(map[*Individual]time.Time),
+			serviceEnds: make(map[*Individual]time.Time),
+		}
+		d.queue = q
+		if d.QueueRegionID == 0 {
+			continue
+		}
+		q.region = s.FindRegion(d.QueueRegionID)
+		q.slots = s.queueTiles(q.region, d)
+		field := s.FlowField(d.ID)
+		dist := func(p image.Point) float64 {
+			if field != nil {
+				return field.Dist(p.X, p.Y)
+			}
+			return math.Hypot(float64(p.X)-q.region.X, float64(p.Y)-q.region.Y)
+		}
+		sort.SliceStable(q.slots, func(a, b int) bool {
+			return dist(q.slots[a]) < dist(q.slots[b])
+		})
+	}
+}
+
+// UpdateQueues lets people leave lines they no longer want to be in, joins people who have reached the back of a
+// line, serves the people at the front and lets them in when there's room. It runs between ticks so lines are the
+// same whichever order the groups are moved in.
+func (s *State) UpdateQueues() {
+	// who is heading for each line and not yet in it, found in one pass over everyone
+	heading := make(map[*Destination][]*Individual)
+	for _, p := range s.allPeople {
+		if p.target != nil && p.target.queue != nil && !p.queued && !p.admitted {
+			heading[p.target] = append(heading[p.target], p)
+		}
+	}
+	for i := range s.scenario.Destinations {
+		d := &s.scenario.Destinations[i]
+		if d.queue != nil {
+			s.updateQueue(d, heading[d])
+		}
+	}
+}
+
+// updateQueue updates the line for d, heading being the people walking to d who aren't in its line
+func (s *State) updateQueue(d *Destination, heading []*Individual) {
+	q := d.queue
+
+	// people who have finished, or have changed their minds, or whose destination has closed
+	q.admitted = keepTargeting(q.admitted, d, func(p *Individual) {
+		p.admitted = false
+	})
+	q.serving = keepTargeting(q.serving, d, func(p *Individual) {
+		p.queued = false
+		delete(q.serviceEnds, p)
+	})
+	q.line = keepTargeting(q.line, d, func(p *Individual) {
+		s.leftQueue(q, d, p, false)
+	})
+
+	// people who have run out of patience
+	line := q.line[:0]
+	for _, p := range q.line {
+		if giveUp, ok := q.giveUp[p]; ok && !s.time.Before(giveUp) {
+			s.leftQueue(q, d, p, false)
+			q.abandoned++
+			s.goElsewhere(p, d)
+		} else {
+			line = append(line, p)
+		}
+	}
+	q.line = line
+
+	for _, p := range heading {
+		if p.target != d || p.queued || p.admitted || !q.reached(d, p) {
+			continue
+		}
+		if d.BalkAt > 0 && len(q.line) >= d.BalkAt {
+			q.balked++
+			s.recordWait(p, d, q.region, s.time, 0, false, true)
+			s.goElsewhere(p, d)
+			continue
+		}
+		p.queued = true
+		q.line = append(q.line, p)
+		q.joined[p] = s.time
+		if d.Patience > 0 {
+			patience := math.Max(0, p.rand.NormFloat64()*d.PatienceVar+d.Patience)
+			q.giveUp[p] = s.time.Add(time.Duration(patience * float64(time.Second)))
+		}
+	}
+
+	// without servers people are let in from the front of the line as soon as there is room
+	if d.Servers == 0 && d.ServiceTime == 0 {
+		for len(q.line) > 0 && q.hasRoom(d) {
+			p := q.line[0]
+			q.line = q.line[1:]
+			s.leftQueue(q, d, p, true)
+			q.admit(p)
+		}
+		for pos, p := range q.line {
+			p.queuePos = pos
+		}
+		return
+	}
+
+	// let in whoever has been served, in the order they were served, while there is room
+	serving := q.serving[:0]
+	for _, p := range q.serving {
+		if !s.time.Before(q.serviceEnds[p]) && q.hasRoom(d) {
+			delete(q.serviceEnds, p)
+			q.admit(p)
+		} else {
+			serving = append(serving, p)
+		}
+	}
+	q.serving = serving
+
+	servers := d.Servers
+	if servers == 0 {
+		servers = 1
+	}
+	for len(q.line) > 0 && len(q.serving) < servers {
+		p := q.line[0]
+		q.line = q.line[1:]
+		s.leftQueue(q, d, p, true)
+		p.queued = true
+		q.serving = append(q.serving, p)
+		mean := d.ServiceTime
+		if mean == 0 {
+			mean = defaultServiceTime
+		}
+		service := math.Max(0, p.rand.NormFloat64()*d.ServiceTimeVar+mean)
+		q.serviceEnds[p] = s.time.Add(time.Duration(service * float64(time.Second)))
+	}
+
+	for pos, p := range q.serving {
+		p.queuePos = pos
+	}
+	for pos, p := range q.line {
+		p.queuePos = len(q.serving) + pos
+	}
+}
+
+func (q *Queue) hasRoom(d *Destination) bool {
+	return d.Capacity == 0 || len(q.admitted) < d.Capacity
+}
+
+func (q *Queue) admit(p *Individual) {
+	p.queued = false
+	p.admitted = true
+	q.admitted = append(q.admitted, p)
+}
+
+// keepTargeting is the people still heading to d, calling gone for each of the others
+func keepTargeting(people []*Individual, d *Destination, gone func(p *Individual)) []*Individual {
+	kept := people[:0]
+	for _, p := range people {
+		if p.target == d {
+			kept = append(kept, p)
+		} else {
+			gone(p)
+		}
+	}
+	return kept
+}
+
+// reached is whether someone heading to d has got to the back of its line
+func (q *Queue) reached(d *Destination, p *Individual) bool {
+	x, y := p.Loc.GetLatestXY()
+	if q.region == nil {
+		return d.ContainsR(int(x), int(y), queueJoinDistance)
+	}
+	backX, backY := q.slotCentre(len(q.serving) + len(q.line))
+	return q.region.Contains(x, y) || d.Contains(int(x), int(y)) || math.Hypot(x-backX, y-backY) < queueJoinDistance
+}
+
+// goElsewhere sends someone who has given up on a line to another destination
+func (s *State) goElsewhere(p *Individual, d *Destination) {
+	p.queued = false
+	p.avoid = d
+	p.leaveTime = time.Time{}
+	p.target = s.scenario.GetDestination(p.requestedDestination(s))
+}
+
+// leftQueue records how long someone waited in line, either until they were served or until they gave up
+func (s *State) leftQueue(q *Queue, d *Destination, p *Individual, served bool) {
+	p.queued = false
+	wait := s.time.Sub(q.joined[p]).Seconds()
+	s.recordWait(p, d, q.region, q.joined[p], wait, served, false)
+	delete(q.joined, p)
+	delete(q.giveUp, p)
+	if served {
+		q.servedAll++
+		q.totalWait += wait
+		q.longest = math.Max(q.longest, wait)
+	}
+}
+
+// slotCentre is the middle of the tile for a place in the line, the back of the line holding anyone beyond it
+func (q *Queue) slotCentre(pos int) (float64, float64) {
+	if pos >= len(q.slots) {
+		pos = len(q.slots) - 1
+	}
+	return float64(q.slots[pos].X) + 0.5, float64(q.slots[pos].Y) + 0.5
+}
+
+// queueDirection is the way someone heading to a destination with a queue should walk: to the back of the line, or
+// to their place in it, or nowhere once they are there. It is false when they should walk to the destination as normal,
+// because they have been let in or are still far away.
+func (i *Individual) queueDirection(w *State) (utils.OptionalFloat64, bool) {
+	q := i.target.queue
+	x, y := i.Loc.GetXY()
+	var toX, toY float64
+	switch {
+	case i.admitted:
+		return utils.OptionalFloat64{}, false
+	case i.queued && len(q.slots) == 0:
+		return utils.OptionalFloat64WithEmptyValue(), true
+	case i.queued:
+		toX, toY = q.slotCentre(i.queuePos)
+		if math.Hypot(toX-x, toY-y) < 0.3 {
+			return utils.OptionalFloat64WithEmptyValue(), true
+		}
+	case len(q.slots) == 0:
+		return utils.OptionalFloat64{}, false
+	default:
+		toX, toY = q.slotCentre(len(q.serving) + len(q.line))
+		if field := w.FlowField(i.target.ID); field != nil {
+			tile := w.GetTileHighRes(x, y)
+			if tile != nil && field.Dist(tile.X, tile.Y) > field.Dist(int(toX), int(toY))+queueApproach {
+				return utils.OptionalFloat64{}, false
+			}
+		}
+	}
+	return utils.OptionalFloat64WithValue(math.Atan2(toY-y, toX-x)), true
+}
+
+// logQueues prints how each line went
+func (s *State) logQueues() {
+	for _, d := range s.scenario.Destinations {
+		q := d.queue
+		if q == nil {
+			continue
+		}
+		mean := 0.0
+		if q.servedAll > 0 {
+			mean = q.totalWait / float64(q.servedAll)
+		}
+		log.Printf("%s queue: %d served, mean wait %.0fs, longest %.0fs, %d balked, %d gave up, %d still waiting",
+			d.Name, q.servedAll, mean, q.longest, q.balked, q.abandoned, len(q.line))
+	}
+}
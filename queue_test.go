@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+)
+
+var queueTestStart = time.Date(2018, 11, 23, 7, 0, 0, 0, time.UTC)
+
+// queueTestState has a single destination with a line, shop, and an exit, and people standing at the shop who
+// want to go nowhere else
+func queueTestState(shop Destination, people int) (*State, *Destination, []*Individual) {
+	shop.Name = "shop"
+	shop.Coords = []Coord{{X: 5, Y: 5, R: 1}}
+	shop.Events = []event{{Name: "open", Start: queueTestStart.Add(-time.Hour), End: queueTestStart.Add(time.Hour), Popularity: 1}}
+	s := &State{
+		scenario: &Scenario{
+			Destinations: []Destination{shop},
+			Exit:         Destination{Name: "exit", Coords: []Coord{{X: 50, Y: 50, R: 1}}},
+		},
+		time: queueTestStart,
+	}
+	s.setupDestinations()
+	s.setupQueues()
+	d := &s.scenario.Destinations[0]
+	d.density = 1
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < people; i++ {
+		p := &Individual{
+			Loc:         geometry.NewPoint(5, 5),
+			Likelihoods: []Likelihood{d.GenerateRandomLikelihood(r)},
+			target:      d,
+			rand:        rand.New(rand.NewSource(int64(i))),
+		}
+		s.allPeople = append(s.allPeople, p)
+	}
+	return s, d, s.allPeople
+}
+
+func TestQueueCapacity(t *testing.T) {
+	s, d, people := queueTestState(Destination{Capacity: 2}, 5)
+	s.UpdateQueues()
+	if len(d.queue.admitted) != 2 || len(d.queue.line) != 3 {
+		t.Fatalf("%d let in and %d in line, want 2 and 3", len(d.queue.admitted), len(d.queue.line))
+	}
+	// someone finishing makes room for the next in line
+	people[0].target = s.scenario.GetDestination(s.scenario.Exit.ID)
+	s.UpdateQueues()
+	if len(d.queue.admitted) != 2 || len(d.queue.line) != 2 || !people[2].admitted {
+		t.Errorf("%d let in and %d in line after one left, want 2 and 2 with the third let in",
+			len(d.queue.admitted), len(d.queue.line))
+	}
+}
+
+func TestQueueBalkWithNowhereElseToGo(t *testing.T) {
+	s, d, people := queueTestState(Destination{Capacity: 1, BalkAt: 2}, 3)
+	s.UpdateQueues()
+	if d.queue.balked != 1 {
+		t.Fatalf("%d balked, want 1", d.queue.balked)
+	}
+	balker := people[2]
+	if balker.queued || balker.admitted || balker.target != s.scenario.GetDestination(s.scenario.Exit.ID) {
+		t.Errorf("the balker is heading for %s, want the exit", balker.target.Name)
+	}
+	if !people[0].admitted || !people[1].queued {
+		t.Error("the first two should have been let in and be waiting in line")
+	}
+}
+
+func TestQueuePatience(t *testing.T) {
+	s, d, people := queueTestState(Destination{Capacity: 1, Patience: 10}, 2)
+	s.UpdateQueues()
+	waiting := people[1]
+	if !waiting.queued {
+		t.Fatal("the second person should be waiting in line")
+	}
+	s.time = queueTestStart.Add(9 * time.Second)
+	s.UpdateQueues()
+	if !waiting.queued || d.queue.abandoned != 0 {
+		t.Fatal("gave up before running out of patience")
+	}
+	s.time = queueTestStart.Add(10 * time.Second)
+	s.UpdateQueues()
+	if waiting.queued || d.queue.abandoned != 1 || len(d.queue.line) != 0 {
+		t.Fatalf("still in line after running out of patience, %d abandoned", d.queue.abandoned)
+	}
+	if waiting.target != s.scenario.GetDestination(s.scenario.Exit.ID) {
+		t.Errorf("gave up and headed for %s, want the exit", waiting.target.Name)
+	}
+}
+
+func TestQueueLine(t *testing.T) {
+	initDeltas()
+	w := gridWorld(
+		"############",
+		"............",
+		"############",
+	)
+	id := w.withDestination(0, 1)
+	w.setFlowField(id, w.computeFlowField(id))
+	w.Regions = []Region{{ID: 1, X: 6.5, Y: 1.5, radius: 5, sqRad: 25}}
+	w.indexRegions()
+	d := &w.scenario.Destinations[0]
+	d.QueueRegionID, d.Servers, d.ServiceTime = 1, 1, 60
+	w.setupQueues()
+
+	// the line starts at the end of the corridor nearest the shop, not in the middle of the queue region
+	q := d.queue
+	if len(q.slots) != 9 {
+		t.Fatalf("line has %d places, want the 9 tiles of the corridor in the region", len(q.slots))
+	}
+	for i, slot := range q.slots {
+		if slot.X != i+2 || slot.Y != 1 {
+			t.Fatalf("place %d in line is %v, want (%d, 1)", i, slot, i+2)
+		}
+	}
+
+	near := &Individual{Loc: geometry.NewPoint(2.5, 1.5), target: d, rand: rand.New(rand.NewSource(1))}
+	far := &Individual{Loc: geometry.NewPoint(11.5, 1.5), target: d, rand: rand.New(rand.NewSource(2))}
+	w.allPeople = []*Individual{near, far}
+	w.UpdateQueues()
+	if len(q.serving) != 1 || near.queuePos != 0 {
+		t.Fatal("someone at the front of an empty line wasn't served")
+	}
+	if far.queued {
+		t.Fatal("joined the line from outside the queue region and away from its back")
+	}
+	far.Loc = geometry.NewPoint(9.5, 1.5)
+	w.UpdateQueues()
+	if !far.queued || far.queuePos != 1 {
+		t.Fatalf("queued %v at %d after walking into the region, want queued second", far.queued, far.queuePos)
+	}
+
+	// someone in line walks to their place in it and stops there
+	direction, ok := far.queueDirection(w)
+	if theta, present := direction.Value(); !ok || !present || math.Abs(theta-math.Pi) > 1e-9 {
+		t.Errorf("walking %v from the back of the region, want towards the front at %v", direction, math.Pi)
+	}
+	far.Loc = geometry.NewPoint(3.5, 1.5)
+	direction, ok = far.queueDirection(w)
+	if _, present := direction.Value(); !ok || present {
+		t.Errorf("walking %v from their place in line, want standing still", direction)
+	}
+}
@@ -1,23 +1,44 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"golang.org/x/exp/errors/fmt"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
-	"net/http"
-	"os"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// update must keep the same fields as mockbackend.Update. The fields after OccurredAt are only filled in for the
+// extended schema, and left out of the JSON otherwise (see MarshalJSON).
 type update struct {
-	UUID       string `json:"uuid"`
-	EventID    int32  `json:"eventId"`
-	RegionID   int32  `json:"regionId"`
-	Entering   bool   `json:"entering"`
-	OccurredAt int64  `json:"occurredAt"`
+	UUID       string  `json:"uuid"`
+	EventID    int32   `json:"eventId"`
+	RegionID   int32   `json:"regionId"`
+	Entering   bool    `json:"entering"`
+	OccurredAt int64   `json:"occurredAt"`
+	Lat        float64 `json:"lat,omitempty"`
+	Lng        float64 `json:"lng,omitempty"`
+	Accuracy   float64 `json:"accuracy,omitempty"`
+	RegionType string  `json:"regionType,omitempty"`
+	Sequence   int64   `json:"sequence,omitempty"`
+}
+
+// MarshalJSON leaves the extended fields out of original schema updates. Extended updates, which always have a
+// sequence number, keep lat and lng even when one of them is 0, as it is all along the prime meridian.
+func (u update) MarshalJSON() ([]byte, error) {
+	type plain update
+	if u.Sequence == 0 {
+		return json.Marshal(plain(u))
+	}
+	return json.Marshal(struct {
+		plain
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	}{plain(u), u.Lat, u.Lng})
 }
 
 type Region struct {
@@ -26,11 +47,22 @@ type Region struct {
 	Type    string  `json:"type"`
 	Lat     float64 `json:"lat,omitempty"`
 	Lng     float64 `json:"lng,omitempty"`
-	Radius  int     `json:"radius,omitempty"`
+	Radius  int     `json:"radius,omitempty"` // metres
 	EventID int32   `json:"eventID"`
 	X       float64 `json:"X"`
 	Y       float64 `json:"Y"`
-	sqRad   float64
+	Colour  string  `json:"colour,omitempty"` // tiles painted this colour on the mask are inside the region too
+	IsQueue bool    `json:"isQueue"`          // the region is the line for a destination, see Destination.QueueRegionID
+
+	Debounce *Debounce `json:"debounce,omitempty"` // replaces any of the scenario's debounce settings it gives
+
+	Polygon      Polygon   `json:"polygon,omitempty"` // outer ring then holes, in tiles
+	MultiPolygon []Polygon `json:"multiPolygon,omitempty"`
+
+	radius   float64 // in tiles
+	sqRad    float64
+	area     Area
+	polygons []Polygon
 }
 
 type UpdateChan struct {
@@ -44,87 +76,101 @@ type NetworkStats struct {
 	queuedUpdates  chan int
 }
 
-func (s *State) LoadRegions(path string, lat, lng float64) {
-	configFile, err := os.Open(path)
+func (s *State) LoadRegions(path string) {
+	err := s.ReadRegions(path)
 	if err != nil {
-		log.Fatal("opening region file", err.Error())
+		log.Fatal(err)
 	}
+}
 
-	var regions []Region
+// ReadRegions loads a regions file, placing any regions without an X and Y by their latitude and longitude. It must
+// be called after setupGeoreference.
+func (s *State) ReadRegions(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("opening region file: %s", err)
+	}
 
-	jsonParser := json.NewDecoder(configFile)
-	if err = jsonParser.Decode(&regions); err != nil {
-		log.Fatal("parsing config file", err.Error())
+	var regions []Region
+	if isGeoJSON(data) {
+		regions, err = parseGeoJSONRegions(data, s.LatLngToTile)
+	} else {
+		err = json.Unmarshal(data, &regions)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing region file: %s", err)
 	}
 
 	for i, _ := range regions {
+		regions[i].polygons = regions[i].shapes()
+		if err := regions[i].checkPolygons(); err != nil {
+			return err
+		}
 		if regions[i].X == 0 || regions[i].Y == 0 {
-			regions[i].X, regions[i].Y = latLngToCoords(regions[i].Lat, regions[i].Lng, lat, lng)
+			if len(regions[i].polygons) > 0 {
+				regions[i].X, regions[i].Y = regions[i].polygons[0].centre()
+			} else {
+				regions[i].X, regions[i].Y, err = s.LatLngToTile(regions[i].Lat, regions[i].Lng)
+				if err != nil {
+					return fmt.Errorf("region %d (%s) has no X and Y: %s", regions[i].ID, regions[i].Name, err)
+				}
+			}
 		}
-		regions[i].sqRad = math.Pow(float64(regions[i].Radius), 2)
+		regions[i].radius = s.metresToTiles(float64(regions[i].Radius))
+		regions[i].sqRad = math.Pow(regions[i].radius, 2)
 		r := regions[i]
-		fmt.Println(i, " - ", r)
-		if r.X < 0 || r.X > float64(s.GetWidth()) ||
-			r.Y < 0 || r.Y > float64(s.GetHeight()) {
+		if r.Colour == "" && len(r.polygons) == 0 && (r.X < 0 || r.X > float64(s.GetWidth()) ||
+			r.Y < 0 || r.Y > float64(s.GetHeight())) {
 			fmt.Printf("Warning! Region: %v - %s is outside the boundries of the world at X,Y: %v, %v\n",
 				r.ID, r.Name, r.X, r.Y)
 		}
 	}
 	s.Regions = regions
+	s.indexRegions()
+	return nil
+}
 
+// StartSenders sets the sink updates are delivered to, and starts the bulk consumer if bulk sending is enabled.
+// It must be called after BulkSend has been set and before any individuals are added.
+func (s *State) StartSenders(sink UpdateSink) {
+	networkStats.runningUpdates = make(chan bool, 10)
+	networkStats.queuedUpdates = make(chan int, 10)
+	s.Sink = sink
 	if s.BulkSend {
 		newList := make([]update, 0)
 		bulkUpdate = &newList
-		updateChannel = make(chan []byte, 50)
-		if s.SendUpdates {
-			startBulkConsumer(updateChannel)
-		} else {
-			startVoidBulkConsumer(updateChannel)
-		}
+		updateChannel = make(chan []update, 50)
+		startBulkConsumer(updateChannel, sink)
 	}
-
-	networkStats.runningUpdates = make(chan bool, 10)
-	networkStats.queuedUpdates = make(chan int, 10)
 }
 
-func latLngToCoords(lat, lng, latOrigin, lngOrigin float64) (float64, float64) {
-	const (
-		MetersPerLat          = 111034.60528834906 // at 45 deg lat
-		MetersPerLngAtEquator = 111319.458
-	)
-	lat -= latOrigin
-	lng -= lngOrigin
-	y := lat * MetersPerLat
-	latRad := lat * math.Pi / 180
-	x := lng * math.Cos(latRad) * MetersPerLngAtEquator
-	return x, y
+// Contains reports whether the point x, y in tiles is in the region's circle, painted area or polygons
+func (r *Region) Contains(x, y float64) bool {
+	return r.sqRad > math.Pow(x-r.X, 2)+math.Pow(y-r.Y, 2) ||
+		r.area.Contains(int(math.Floor(x)), int(math.Floor(y))) || r.inPolygons(x, y)
 }
 
+// UpdateRegions tracks which regions an individual is really in. Update senders report the changes straight away,
+// unless the scenario has sensors, when SenseRegions reports what their phones notice instead, or debouncing, when
+// DebounceRegions reports the debounced changes.
 func UpdateRegions(world *State, individual *Individual, time time.Time, bulk bool) {
 	x, y := individual.Loc.GetLatestXY()
-	for _, r := range world.Regions {
-		dx := x - r.X
-		dy := y - r.Y
-		distanceSquared := math.Pow(dx, 2) + math.Pow(dy, 2)
-		if r.sqRad > distanceSquared {
+	for _, i := range world.regionsToCheck(individual, x, y) {
+		r := world.Regions[i]
+		if r.Contains(x, y) {
 			// this individual is in this region
 			//_, knownInside := individual.RegionIds[r.ID]
 			if !individual.RegionIds[r.ID] {
 				individual.RegionIds[r.ID] = true
+				world.recordRegionEvent(individual, &r, true)
 				dest := world.scenario.GetRegionDestination(&r)
 				if dest != nil {
 					atomic.AddInt64(&dest.population, 1)
 				}
-				if individual.UpdateSender {
+				if individual.UpdateSender && world.sendsTruth() {
 					// we must send update to backend
 					u := update{EventID: r.EventID, RegionID: r.ID, UUID: individual.UUID, Entering: true, OccurredAt: time.Unix()}
-					networkStats.totalUpdates++
-					networkStats.queuedUpdates <- 1
-					if bulk {
-						*bulkUpdate = append(*bulkUpdate, u)
-					} else {
-						individual.UpdateChan.updates <- u
-					}
+					world.queueUpdate(individual, u, bulk)
 				}
 			}
 		} else {
@@ -132,21 +178,16 @@ func UpdateRegions(world *State, individual *Individual, time time.Time, bulk bo
 			//_, knownInside := individual.RegionIds[r.ID]
 			if individual.RegionIds[r.ID] {
 				individual.RegionIds[r.ID] = false
+				world.recordRegionEvent(individual, &r, false)
 				dest := world.scenario.GetRegionDestination(&r)
 				if dest != nil {
 					atomic.AddInt64(&dest.population, -1)
 				}
-				if individual.UpdateSender {
+				if individual.UpdateSender && world.sendsTruth() {
 
 					// we must send update to backend to say this individual is no longer in the region.
 					u := update{EventID: r.EventID, RegionID: r.ID, UUID: individual.UUID, Entering: false, OccurredAt: time.Unix()}
-					networkStats.totalUpdates++
-					networkStats.queuedUpdates <- 1
-					if bulk {
-						*bulkUpdate = append(*bulkUpdate, u)
-					} else {
-						individual.UpdateChan.updates <- u
-					}
+					world.queueUpdate(individual, u, bulk)
 				}
 			}
 		}
@@ -155,95 +196,103 @@ func UpdateRegions(world *State, individual *Individual, time time.Time, bulk bo
 }
 
 func LeaveAllRegions(state *State, individual *Individual, time time.Time, bulk bool) {
-	if !individual.UpdateSender {
-		return
-	}
+	// leave in a fixed order so seeded runs send identical updates
+	ids := make([]int, 0, len(individual.RegionIds))
 	for rID, b := range individual.RegionIds {
 		if b {
-			r := state.FindRegion(rID)
+			ids = append(ids, int(rID))
+		}
+	}
+	sort.Ints(ids)
+	for _, rID := range ids {
+		r := state.FindRegion(int32(rID))
+		state.recordRegionEvent(individual, r, false)
+		if individual.UpdateSender && state.sendsTruth() {
 			u := update{EventID: r.EventID, RegionID: r.ID, UUID: individual.UUID, Entering: false, OccurredAt: time.Unix()}
-			networkStats.totalUpdates++
-			networkStats.queuedUpdates <- 1
-			if bulk {
-				*bulkUpdate = append(*bulkUpdate, u)
-			} else {
-				individual.UpdateChan.updates <- u
-			}
+			state.queueUpdate(individual, u, bulk)
+		}
+	}
+	if individual.UpdateSender && state.sensing() {
+		state.leaveSensedRegions(individual)
+	}
+	if individual.UpdateSender && state.debouncing() {
+		state.leaveDebouncedRegions(individual)
+	}
+	if individual.UpdateSender {
+		individual.UpdateChan.exited <- true
+	}
+}
+
+func (s *State) queueUpdate(individual *Individual, u update, bulk bool) {
+	if s.scenario.extendedUpdates() {
+		s.extendUpdate(individual, &u)
+		if s.recorder != nil {
+			s.recorder.recordSent(u, s.sensing(), s.sendsDebounced())
 		}
 	}
-	individual.UpdateChan.exited <- true
+	if s.produced != nil {
+		*s.produced = append(*s.produced, u)
+	}
+	networkStats.totalUpdates++
+	networkStats.queuedUpdates <- 1
+	if bulk {
+		*bulkUpdate = append(*bulkUpdate, u)
+	} else {
+		individual.UpdateChan.updates <- u
+	}
+}
+
+func startPersonalSender(sender *UpdateChan, sink UpdateSink) {
+	senders.Add(1)
+	go func() {
+		defer senders.Done()
+		PersonalUpdateDemon(sender, sink)
+	}()
 }
 
-func PersonalUpdateDemon(sender *UpdateChan, send bool) {
+func PersonalUpdateDemon(sender *UpdateChan, sink UpdateSink) {
 	for {
 		select {
 		case u := <-sender.updates:
-			handleUpdate(send, &u)
+			handleUpdate(sink, &u)
 		case <-sender.exited:
 			for len(sender.updates) > 0 {
 				u := <-sender.updates
-				handleUpdate(send, &u)
+				handleUpdate(sink, &u)
 			}
 			return
 		}
 	}
 }
-func handleUpdate(send bool, u *update) {
+func handleUpdate(sink UpdateSink, u *update) {
 	networkStats.queuedUpdates <- -1
 	networkStats.runningUpdates <- true
-	if send {
-		sendUpdate(u)
-	} else {
-		time.Sleep(1000 * time.Millisecond)
-	}
-	networkStats.runningUpdates <- false
-}
-
-const url = "http://api.jackchorley.club/update"
-
-func sendUpdate(u *update) {
-	var jsonStr, err = json.Marshal(*u)
-	if err != nil {
-		log.Fatal("Cannot marshal update: ", *u)
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+	err := sink.Send(u)
 	if err != nil {
-		log.Print("Cannot endcode update to json", err)
-	}
-	//req.Header.Set("X-Custom-Header", "myvalue")
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Print("Cannot connect to backend", err)
-	} else {
-		if resp.StatusCode != http.StatusOK {
-			log.Println("Error sending update: ", resp.Status)
-		}
-		err := resp.Body.Close()
-		if err != nil {
-			log.Println("cannot close http response, don't care")
-		}
+		log.Println("Error sending update: ", err)
 	}
+	networkStats.runningUpdates <- false
 }
 
-const bulkUrl = "http://api.jackchorley.club/bulkUpdate"
-
 var bulkUpdate *[]update
-var updateChannel chan []byte
+var updateChannel chan []update
 var networkStats NetworkStats
+var senders sync.WaitGroup
 
 func GetTotalUpdates() int {
 	return networkStats.totalUpdates
 }
 
 func SendBulk() {
+	sendBulk(10)
+}
+
+func sendBulk(threshold int) {
 	if bulkUpdate == nil {
 		log.Println("total updates so far:", networkStats.totalUpdates)
 		return
 	}
-	if len(*bulkUpdate) < 10 {
+	if len(*bulkUpdate) < threshold || len(*bulkUpdate) == 0 {
 		return
 	}
 	networkStats.queuedUpdates <- -len(*bulkUpdate)
@@ -251,46 +300,40 @@ func SendBulk() {
 	newList := make([]update, 0)
 	bulkUpdate = &newList
 
-	var jsonStr, err = json.Marshal(*updateList)
+	updateChannel <- *updateList
+}
+
+// StopSenders delivers everything still queued for the individuals left in the world, waits for it to reach the
+// sink and then closes the sink
+func (s *State) StopSenders() {
+	for _, p := range s.allPeople {
+		if p.UpdateSender {
+			p.UpdateChan.exited <- true
+		}
+	}
+	if s.BulkSend {
+		sendBulk(1)
+		close(updateChannel)
+	}
+	senders.Wait()
+	err := s.Sink.Close()
 	if err != nil {
-		log.Fatal("Cannot marshal bulk update:")
+		log.Println("Error closing update sink: ", err)
 	}
-	updateChannel <- jsonStr
 }
 
-func startBulkConsumer(jsonChannel chan []byte) {
+func startBulkConsumer(updateChannel chan []update, sink UpdateSink) {
+	senders.Add(1)
 	go func() {
-		for {
-			jsonStr := <-jsonChannel
+		defer senders.Done()
+		for updates := range updateChannel {
 			networkStats.runningUpdates <- true
-			log.Println(len(jsonChannel), " updates buffered")
-			buffer := bytes.NewBuffer(jsonStr)
-			req, err := http.NewRequest("POST", bulkUrl, buffer)
-			//req.Header.Set("X-Custom-Header", "myvalue")
-			req.Header.Set("Content-Type", "application/json")
-			client := &http.Client{}
-			resp, err := client.Do(req)
+			log.Println(len(updateChannel), " updates buffered")
+			err := sink.SendBulk(updates)
 			if err != nil {
-				log.Print("Cannot connect to backend")
-			} else {
-				if resp.StatusCode != http.StatusOK {
-					log.Println("Error sending update: ", resp.Status)
-				}
-				err := resp.Body.Close()
-				if err != nil {
-					log.Println("cannot close http response, don't care")
-				}
+				log.Println("Error sending bulk update: ", err)
 			}
 			networkStats.runningUpdates <- false
 		}
 	}()
 }
-
-func startVoidBulkConsumer(jsonChannel chan []byte) {
-	go func() {
-		for {
-			<-jsonChannel
-			log.Println("Bulk update Voided")
-		}
-	}()
-}
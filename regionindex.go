@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// indexRegions maps region IDs to regions and builds the grid of regions which might contain a point in each tile,
+// so moving people only test the regions near them. It must be rerun whenever a region's shape changes.
+func (s *State) indexRegions() {
+	s.regionIndex = make(map[int32]int, len(s.Regions))
+	s.regionGrid = make([][]int, s.width*s.height)
+	for i := range s.Regions {
+		r := &s.Regions[i]
+		if _, ok := s.regionIndex[r.ID]; !ok {
+			s.regionIndex[r.ID] = i
+		}
+		if r.radius > 0 {
+			s.addRegionToGrid(s.regionGrid, i, r.X-r.radius, r.Y-r.radius, r.X+r.radius, r.Y+r.radius)
+		}
+		if len(r.polygons) > 0 {
+			minX, minY, maxX, maxY := r.polygonBounds()
+			s.addRegionToGrid(s.regionGrid, i, minX, minY, maxX, maxY)
+		}
+		for _, p := range r.area.Points() {
+			s.addRegionToGrid(s.regionGrid, i, float64(p.X), float64(p.Y), float64(p.X), float64(p.Y))
+		}
+	}
+}
+
+// addRegionToGrid adds region i to every tile of grid touching the box, keeping each tile's list in region order
+func (s *State) addRegionToGrid(grid [][]int, i int, minX, minY, maxX, maxY float64) {
+	x0, y0 := int(math.Max(0, math.Floor(minX))), int(math.Max(0, math.Floor(minY)))
+	x1, y1 := int(math.Min(float64(s.width-1), math.Floor(maxX))), int(math.Min(float64(s.height-1), math.Floor(maxY)))
+	for x := x0; x <= x1; x++ {
+		for y := y0; y <= y1; y++ {
+			cell := &grid[x*s.height+y]
+			if n := len(*cell); n == 0 || (*cell)[n-1] != i {
+				*cell = append(*cell, i)
+			}
+		}
+	}
+}
+
+// regionsToCheck is, in region order, every region the individual at x, y could be entering or leaving: those near
+// them and those they are currently in
+func (s *State) regionsToCheck(individual *Individual, x, y float64) []int {
+	var near []int
+	tx, ty := int(math.Floor(x)), int(math.Floor(y))
+	if tx >= 0 && tx < s.width && ty >= 0 && ty < s.height {
+		near = s.regionGrid[tx*s.height+ty]
+	}
+	var inside []int
+	for id, in := range individual.RegionIds {
+		if i, ok := s.regionIndex[id]; in && ok {
+			inside = append(inside, i)
+		}
+	}
+	if len(inside) == 0 {
+		return near
+	}
+	candidates := append(append(make([]int, 0, len(near)+len(inside)), near...), inside...)
+	sort.Ints(candidates)
+	unique := candidates[:1]
+	for _, i := range candidates[1:] {
+		if i != unique[len(unique)-1] {
+			unique = append(unique, i)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestRegionsToCheck(t *testing.T) {
+	rows := make([]string, 20)
+	for i := range rows {
+		rows[i] = strings.Repeat(".", 20)
+	}
+	w := gridWorld(rows...)
+	w.Regions = []Region{
+		{ID: 7, X: 5, Y: 5, radius: 2, sqRad: 4},
+		{ID: 8, polygons: []Polygon{{{{10, 10}, {14, 10}, {14, 14}, {10, 14}}}}},
+		{ID: 9, X: 18.5, Y: 1.5},
+	}
+	w.Regions[2].area.add(image.Point{X: 18, Y: 1})
+	w.indexRegions()
+
+	// every region containing a point is a candidate there
+	nobody := &Individual{}
+	for x := 0.125; x < 20; x += 0.25 {
+		for y := 0.125; y < 20; y += 0.25 {
+			candidates := w.regionsToCheck(nobody, x, y)
+			for i := range w.Regions {
+				if w.Regions[i].Contains(x, y) && !containsInt(candidates, i) {
+					t.Errorf("region %d contains %v, %v but isn't a candidate there", w.Regions[i].ID, x, y)
+				}
+			}
+		}
+	}
+	if candidates := w.regionsToCheck(nobody, 1.5, 18.5); len(candidates) != 0 {
+		t.Errorf("candidates far from every region are %v, want none", candidates)
+	}
+
+	// the regions someone is in are checked wherever they are, so they can leave them
+	inside := &Individual{RegionIds: map[int32]bool{9: true, 7: true, 8: false}}
+	if candidates := w.regionsToCheck(inside, 11, 11); len(candidates) != 3 ||
+		candidates[0] != 0 || candidates[1] != 1 || candidates[2] != 2 {
+		t.Errorf("candidates are %v, want [0 1 2]", candidates)
+	}
+	if r := w.FindRegion(8); r != &w.Regions[1] {
+		t.Errorf("region 8 is %v", r)
+	}
+}
+
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -106,7 +106,7 @@ func SetupRender(s screen.Screen, original image.Image, regions *[]Region, state
 		fmt.Println("region: ", region.Name, region.X, region.Y)
 		red, green, blue := color.YCbCrToRGB(uint8(50), uint8(rand.Intn(256)), uint8(rand.Intn(256)))
 		c := color.RGBA{R: red, G: green, B: blue, A: 150}
-		drawRegionInBuffer(&r, region.X, region.Y, c, region.Radius)
+		drawRegionInBuffer(&r, region.X, region.Y, c, region.radius)
 	}
 
 	r.rt.Upload(image.Point{}, r.rb, r.rb.Bounds())
@@ -204,14 +204,15 @@ func (r *RenderState) Step() bool {
 				for _, p := range tile.People {
 					//fmt.Print(p)
 					x, y := p.Loc.GetLatestXY()
+					// people are drawn their own size, update senders twice it when highlighted
 					if r.world.highlightActive {
 						if p.UpdateSender {
-							drawPersonInBuffer(r, x, y, colornames.Red, 1)
+							drawPersonInBuffer(r, x, y, colornames.Red, 2*p.radius)
 						} else {
-							drawPersonInBuffer(r, x, y, colornames.Gray, 0.5)
+							drawPersonInBuffer(r, x, y, colornames.Gray, p.radius)
 						}
 					} else {
-						drawPersonInBuffer(r, x, y, p.Colour, 0.5)
+						drawPersonInBuffer(r, x, y, p.Colour, p.radius)
 					}
 				}
 			}
@@ -223,11 +224,12 @@ func (r *RenderState) Step() bool {
 	return true
 }
 
-func drawPersonInBuffer(r *RenderState, x, y float64, c color.Color, p float64) {
+// drawPersonInBuffer draws someone with a radius of rad tiles, and at least a pixel
+func drawPersonInBuffer(r *RenderState, x, y float64, c color.Color, rad float64) {
 	ix := int(x * float64(r.backgroundScale))
 	iy := int(y * float64(r.backgroundScale))
 
-	pr := float64(r.backgroundScale) * p
+	pr := float64(r.backgroundScale) * rad
 	if pr < 1 {
 		pr = 1
 	}
@@ -242,10 +244,10 @@ func drawPersonInBuffer(r *RenderState, x, y float64, c color.Color, p float64)
 	//r.b.RGBA().Set(ix, iy, c)
 }
 
-func drawRegionInBuffer(r *RenderState, x, y float64, c color.Color, rad int) {
+func drawRegionInBuffer(r *RenderState, x, y float64, c color.Color, rad float64) {
 	ix := int(x * float64(r.backgroundScale))
 	iy := int(y * float64(r.backgroundScale))
-	pr := r.backgroundScale * rad
+	pr := int(float64(r.backgroundScale) * rad)
 	if pr < 2 {
 		pr = 2
 	}
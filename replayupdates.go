@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// ReadUpdates reads a file of updates. Each top level JSON value may be a single update, as written by the file
+// sink, or an array of them, as posted to /bulkUpdate. The updates are returned in OccurredAt order.
+func ReadUpdates(path string) ([]update, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	updates := make([]update, 0)
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > 0 && raw[0] == '[' {
+			var list []update
+			err = json.Unmarshal(raw, &list)
+			updates = append(updates, list...)
+		} else {
+			var u update
+			err = json.Unmarshal(raw, &u)
+			updates = append(updates, u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("update %d: %s", len(updates), err)
+		}
+	}
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updates[i].OccurredAt < updates[j].OccurredAt
+	})
+	return updates, nil
+}
+
+// ReplayUpdates re-sends recorded updates through the usual sender path, one sender per device. Updates are spaced
+// out by their OccurredAt times divided by speed, or sent as fast as possible if speed is 0. In bulk mode the updates
+// for each second are batched together.
+func (s *State) ReplayUpdates(updates []update, speed float64) {
+	if len(updates) == 0 {
+		return
+	}
+	devices := make(map[string]*Individual)
+	start := time.Now()
+	first := updates[0].OccurredAt
+	for i, u := range updates {
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(time.Duration(u.OccurredAt-first)*time.Second) / speed))
+			time.Sleep(time.Until(due))
+		}
+
+		device, ok := devices[u.UUID]
+		if !ok {
+			updateChan := UpdateChan{make(chan update, 50), make(chan bool)}
+			device = &Individual{UUID: u.UUID, UpdateSender: true, UpdateChan: &updateChan}
+			startPersonalSender(&updateChan, s.Sink)
+			devices[u.UUID] = device
+			s.allPeople = append(s.allPeople, device)
+		}
+		s.queueUpdate(device, u, s.BulkSend)
+
+		if s.BulkSend && (i+1 == len(updates) || updates[i+1].OccurredAt != u.OccurredAt) {
+			sendBulk(1)
+		}
+		if (i+1)%10000 == 0 {
+			log.Println("replayed", i+1, "of", len(updates), "updates")
+		}
+	}
+	log.Println("replayed", len(updates), "updates to", len(devices), "devices in", time.Since(start))
+}
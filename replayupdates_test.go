@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.json")
+	data := `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":30}
+[{"uuid":"b","eventId":1,"regionId":2,"entering":true,"occurredAt":10},
+ {"uuid":"a","eventId":1,"regionId":2,"entering":false,"occurredAt":40,"lat":51.5,"lng":0,"sequence":2}]
+{"uuid":"c","eventId":1,"regionId":3,"entering":true,"occurredAt":10}
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := ReadUpdates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []update{
+		{UUID: "b", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 10},
+		{UUID: "c", EventID: 1, RegionID: 3, Entering: true, OccurredAt: 10},
+		{UUID: "a", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 30},
+		{UUID: "a", EventID: 1, RegionID: 2, OccurredAt: 40, Lat: 51.5, Sequence: 2},
+	}
+	if len(updates) != len(want) {
+		t.Fatalf("got %d updates, want %d", len(updates), len(want))
+	}
+	for i := range want {
+		if updates[i] != want[i] {
+			t.Errorf("update %d is %+v, want %+v", i, updates[i], want[i])
+		}
+	}
+}
+
+func TestReadUpdatesBadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.json")
+	if err := ioutil.WriteFile(path, []byte(`{"uuid":"a","occurredAt":"soon"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadUpdates(path); err == nil {
+		t.Error("read an update with a string occurredAt")
+	}
+	if _, err := ReadUpdates(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("read a missing file")
+	}
+}
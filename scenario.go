@@ -1,34 +1,58 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"math"
 	"math/rand"
-	"os"
 	"strings"
 	"time"
 )
 
 type Scenario struct {
-	MapImage     string        `json:"map"`
-	RegionsFile  string        `json:"regions"`
-	Lat          float64       `json:"lat,omitempty"`
-	Lng          float64       `json:"lng,omitempty"`
-	Start        time.Time     `json:"start,string"`
-	End          time.Time     `json:"end,string"`
-	Entrances    []Coord       `json:"entrance"`
-	Exit         Destination   `json:"exit"`
-	TotalPeople  int           `json:"totalPeople"`
-	TotalGroups  int           `json:"totalGroups"`
-	Destinations []Destination `json:"Destinations"`
-	destMap      map[int]*Destination
+	MapImage      string          `json:"map"`
+	RegionsFile   string          `json:"regions"`
+	Lat           float64         `json:"lat,omitempty"`
+	Lng           float64         `json:"lng,omitempty"`
+	Start         time.Time       `json:"start,string"`
+	End           time.Time       `json:"end,string"`
+	Entrances     []Coord         `json:"entrance"`
+	Exit          Destination     `json:"exit"`
+	TotalPeople   int             `json:"totalPeople"`
+	TotalGroups   int             `json:"totalGroups"` // unused if people arrive in groups
+	Groups        *Groups         `json:"groups,omitempty"`
+	People        *People         `json:"people,omitempty"` // everyone is the same if nil
+	Crowding      *Crowding       `json:"crowding,omitempty"`
+	Steering      *SteeringConfig `json:"steering,omitempty"`
+	Destinations  []Destination   `json:"Destinations"`
+	Sink          *SinkConfig     `json:"sink,omitempty"`
+	Terrain       []TerrainClass  `json:"terrain,omitempty"`
+	Mask          string          `json:"mask,omitempty"` // image the areas of destinations and regions are painted on, the map if empty
+	Georef        *Georeference   `json:"georef,omitempty"`
+	MetresPerTile float64         `json:"metresPerTile,omitempty"` // worked out from georef if not given, else 1
+	UpdateFormat  *UpdateFormat   `json:"updateFormat,omitempty"`
+	Sensors       *Sensors        `json:"sensors,omitempty"` // regions are entered and left exactly when they are if nil
+	Debounce      *Debounce       `json:"debounce,omitempty"`
+	destMap       map[int]*Destination
+	regionDests   map[int32]*Destination
 }
 
 type Destination struct {
 	Coords   []Coord `json:"coords"`
 	RegionID int32   `json:"regionId,omitempty"`
+	Colour   string  `json:"colour,omitempty"` // tiles painted this colour on the mask are part of the destination
 	ID       DestinationID
+	area     Area
+
+	// A destination with a queue region, capacity or servers has a line, see Queue
+	QueueRegionID  int32   `json:"queueRegionId,omitempty"`  // people line up in this region
+	Capacity       int     `json:"capacity,omitempty"`       // most people let in at once, unlimited if 0
+	Servers        int     `json:"servers,omitempty"`        // people served at once at the front of the line, 1 if 0 with a service time, else nobody is served
+	ServiceTime    float64 `json:"serviceTime,omitempty"`    // mean seconds to serve someone, 10 by default with servers
+	ServiceTimeVar float64 `json:"serviceTimeVar,omitempty"` // standard deviation of the service time
+	BalkAt         int     `json:"balkAt,omitempty"`         // people arriving to a line this long go elsewhere, never if 0
+	Patience       float64 `json:"patience,omitempty"`       // mean seconds people wait in line before giving up, for ever if 0
+	PatienceVar    float64 `json:"patienceVar,omitempty"`
+	queue          *Queue
 
 	Name       string  `json:"name"`
 	Events     []event `json:"events"`
@@ -54,38 +78,76 @@ type event struct {
 }
 
 func LoadScenario(path string) State {
-	if !strings.HasSuffix(path, ".json") {
-		log.Fatal("Scenario must be a .json file")
-	}
-	configFile, err := os.Open(path)
-	if err != nil {
-		log.Fatal("opening region file", err.Error())
+	s, problems := ReadScenario(path)
+	if len(problems) > 0 {
+		for _, p := range problems {
+			log.Println(p)
+		}
+		log.Fatalf("%s has %d problem(s), see above", path, len(problems))
 	}
+	return *s
+}
 
-	var scenario Scenario
-
-	jsonParser := json.NewDecoder(configFile)
-	if err = jsonParser.Decode(&scenario); err != nil {
-		log.Fatal("parsing config file", err.Error())
+// ReadScenario loads and validates a scenario, returning every problem found rather than stopping at the first
+func ReadScenario(path string) (*State, []ValidationError) {
+	if !strings.HasSuffix(path, ".json") {
+		return nil, []ValidationError{{"$", "scenario must be a .json file"}}
+	}
+	scenario, problems := parseScenario(path)
+	if scenario == nil {
+		return nil, problems
 	}
 	log.Println("map: ", scenario.MapImage)
-	s := LoadFromImage(scenario.MapImage)
-	s.scenario = &scenario
+	s, err := ReadImage(scenario.MapImage)
+	if err != nil {
+		return nil, append(problems, ValidationError{"$.map", err.Error()})
+	}
+	s.scenario = scenario
 	s.time = scenario.Start
 	s.ScenarioName = strings.TrimSuffix(path, ".json")
-	s.LoadRegions(scenario.RegionsFile, scenario.Lat, scenario.Lng)
+	s.OutputDir = s.ScenarioName
+	if geoProblems := s.setupGeoreference(); len(geoProblems) > 0 {
+		return nil, append(problems, geoProblems...)
+	}
+	err = s.ReadRegions(scenario.RegionsFile)
+	if err != nil {
+		return nil, append(problems, ValidationError{"$.regions", err.Error()})
+	}
+	err = s.ReadAreas()
+	if err != nil {
+		return nil, append(problems, ValidationError{"$.mask", err.Error()})
+	}
+	problems = append(problems, s.validateScenario()...)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	s.applyTerrain()
+	s.setupDestinations()
+	s.setupSensors()
+	s.setupSteering()
+	return &s, nil
+}
 
+// setupDestinations assigns IDs to the destinations, adds the exit as the last of them and adds the area of each
+// destination's region
+func (s *State) setupDestinations() {
+	scenario := s.scenario
 	scenario.destMap = make(map[int]*Destination)
+	scenario.regionDests = make(map[int32]*Destination)
 	idCount := 1
 	for i, d := range scenario.Destinations {
 		if d.RegionID > 0 {
 			region := s.FindRegion(d.RegionID)
-			coord := Coord{
-				X: int(region.X),
-				Y: int(region.Y),
-				R: float64(region.Radius),
+			tiles := s.regionTiles(region)
+			if region.Radius > 0 || tiles.Len() == 0 {
+				coord := Coord{
+					X: int(region.X),
+					Y: int(region.Y),
+					R: region.radius,
+				}
+				scenario.Destinations[i].Coords = append(scenario.Destinations[i].Coords, coord)
 			}
-			scenario.Destinations[i].Coords = append(scenario.Destinations[i].Coords, coord)
+			scenario.Destinations[i].area.addArea(tiles)
 		}
 
 		scenario.Destinations[i].ID = DestinationID{idCount}
@@ -97,23 +159,39 @@ func LoadScenario(path string) State {
 
 	for i, d := range scenario.Destinations {
 		scenario.destMap[d.ID.ID] = &scenario.Destinations[i]
+		if _, ok := scenario.regionDests[d.RegionID]; d.RegionID > 0 && !ok {
+			scenario.regionDests[d.RegionID] = &scenario.Destinations[i]
+		}
 		for _, c := range scenario.Destinations[i].Coords {
 			scenario.Destinations[i].volume += math.Pi * float64(c.R*c.R)
 		}
+		scenario.Destinations[i].volume += float64(scenario.Destinations[i].area.Len())
 	}
 
 	log.Println(scenario)
-	log.Println(scenario.Destinations[0].Events[0].Start)
-	log.Println(scenario.Destinations[0].Events[0].End)
+}
 
-	return s
+// ChooseSink builds the update sink from the command line spec if given, falling back to the scenario's sink and
+// then to a sink which drops everything
+func (s *Scenario) ChooseSink(spec string) (UpdateSink, error) {
+	if spec != "" {
+		config, err := ParseSinkSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		return NewUpdateSink(config)
+	}
+	if s.Sink != nil {
+		return NewUpdateSink(*s.Sink)
+	}
+	return NoopSink{}, nil
 }
 
-func (s *Scenario) GenerateRandomPersonality() []Likelihood {
+func (s *Scenario) GenerateRandomPersonality(r *rand.Rand) []Likelihood {
 	var ls []Likelihood
 
 	for _, d := range s.Destinations {
-		ls = append(ls, d.GenerateRandomLikelihood())
+		ls = append(ls, d.GenerateRandomLikelihood(r))
 	}
 	return ls
 }
@@ -123,15 +201,10 @@ func (s *Scenario) GetDestination(target DestinationID) *Destination {
 }
 
 func (s *Scenario) GetRegionDestination(region *Region) *Destination {
-	for i := range s.Destinations {
-		if s.Destinations[i].RegionID == region.ID {
-			return &s.Destinations[i]
-		}
-	}
-	return nil
+	return s.regionDests[region.ID]
 }
 
-func (d *Destination) GenerateRandomLikelihood() Likelihood {
+func (d *Destination) GenerateRandomLikelihood(r *rand.Rand) Likelihood {
 	l := Likelihood{
 		Destination: d.ID,
 	}
@@ -147,7 +220,7 @@ func (d *Destination) GenerateRandomLikelihood() Likelihood {
 				}
 				return false
 			}
-		prob := e.Popularity * rand.Float64() * 20
+		prob := e.Popularity * r.Float64() * 20
 
 		l.ProbabilityFunctions = append(l.ProbabilityFunctions, pfunc)
 		l.Probabilities = append(l.Probabilities, prob)
@@ -175,6 +248,9 @@ func (d *Destination) NextEventToEnd(t time.Time) *event {
 }
 
 func (d *Destination) Contains(x, y int) bool {
+	if d.area.Contains(x, y) {
+		return true
+	}
 	for _, c := range d.Coords {
 		dxsq := (c.X - x) * (c.X - x)
 		dysq := (c.Y - y) * (c.Y - y)
@@ -186,6 +262,9 @@ func (d *Destination) Contains(x, y int) bool {
 }
 
 func (d *Destination) ContainsR(x, y int, r float64) bool {
+	if d.area.Contains(x, y) {
+		return true
+	}
 	for _, c := range d.Coords {
 		dxsq := (c.X - x) * (c.X - x)
 		dysq := (c.Y - y) * (c.Y - y)
@@ -196,7 +275,11 @@ func (d *Destination) ContainsR(x, y int, r float64) bool {
 	return false
 }
 
+// ContainsCenter reports whether x, y is one of the tiles the destination's flow field starts from
 func (d *Destination) ContainsCenter(x, y int) bool {
+	if d.area.Contains(x, y) {
+		return true
+	}
 	for _, c := range d.Coords {
 		if c.X == x && c.Y == y {
 			return true
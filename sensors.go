@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Sensors makes update senders notice regions the way real phones do. Beacon regions are found by scanning for a
+// Bluetooth beacon at their centre and GPS regions by periodic, noisy position fixes; regions of any other type, or
+// of a type with no sensor configured, are reported the moment they are entered or left, as they are with no Sensors.
+type Sensors struct {
+	Beacon *BeaconSensor `json:"beacon,omitempty"`
+	GPS    *GPSSensor    `json:"gps,omitempty"`
+}
+
+// BeaconSensor models received signal strength falling off with distance from the beacon, with Gaussian noise. A scan
+// sees the beacon when its strength is above what it averages at the region's radius, so people at the edge are seen
+// half the time. The region is left once the beacon has gone unseen for ExitTimeout, as phones do.
+type BeaconSensor struct {
+	TxPower      float64 `json:"txPower,omitempty"`      // dBm at a metre, -59 by default
+	PathLoss     float64 `json:"pathLoss,omitempty"`     // exponent of the fall off, 2 (open air) by default
+	Noise        float64 `json:"noise,omitempty"`        // standard deviation in dB, 4 by default
+	ScanInterval float64 `json:"scanInterval,omitempty"` // seconds between scans, 1 by default
+	ExitTimeout  float64 `json:"exitTimeout,omitempty"`  // seconds, 30 by default
+	MissRate     float64 `json:"missRate,omitempty"`     // chance a scan misses a beacon it should have seen
+}
+
+// GPSSensor models a fix every FixInterval seconds with Gaussian noise on each coordinate. Regions are entered and
+// left as the fixes move in and out of them.
+type GPSSensor struct {
+	Noise       float64 `json:"noise,omitempty"`       // standard deviation in metres, 5 by default
+	FixInterval float64 `json:"fixInterval,omitempty"` // seconds between fixes, 5 by default
+	MissRate    float64 `json:"missRate,omitempty"`    // chance a fix is lost
+}
+
+func (b BeaconSensor) withDefaults() BeaconSensor {
+	if b.TxPower == 0 {
+		b.TxPower = -59
+	}
+	if b.PathLoss == 0 {
+		b.PathLoss = 2
+	}
+	if b.Noise == 0 {
+		b.Noise = 4
+	}
+	if b.ScanInterval == 0 {
+		b.ScanInterval = 1
+	}
+	if b.ExitTimeout == 0 {
+		b.ExitTimeout = 30
+	}
+	return b
+}
+
+func (g GPSSensor) withDefaults() GPSSensor {
+	if g.Noise == 0 {
+		g.Noise = 5
+	}
+	if g.FixInterval == 0 {
+		g.FixInterval = 5
+	}
+	return g
+}
+
+// rssi is the mean strength of a beacon d metres away
+func (b *BeaconSensor) rssi(d float64) float64 {
+	return b.TxPower - 10*b.PathLoss*math.Log10(math.Max(d, 0.1))
+}
+
+// distance is how far away a beacon seen at strength rssi is estimated to be, as phones report it
+func (b *BeaconSensor) distance(rssi float64) float64 {
+	return math.Pow(10, (b.TxPower-rssi)/(10*b.PathLoss))
+}
+
+// maxRange is the distance, as a multiple of the radius, beyond which a beacon is seen less than once in a
+// thousand scans
+func (b *BeaconSensor) maxRange() float64 {
+	return math.Pow(10, 3*b.Noise/(10*b.PathLoss))
+}
+
+func (s *Scenario) validateSensors() []ValidationError {
+	var problems []ValidationError
+	add := func(path string, v float64, max float64) {
+		if v < 0 || (max > 0 && v >= max) {
+			message := "must not be negative"
+			if max > 0 {
+				message = fmt.Sprintf("must be at least 0 and less than %v", max)
+			}
+			problems = append(problems, ValidationError{path, message})
+		}
+	}
+	if s.Sensors == nil {
+		return nil
+	}
+	if b := s.Sensors.Beacon; b != nil {
+		add("$.sensors.beacon.pathLoss", b.PathLoss, 0)
+		add("$.sensors.beacon.noise", b.Noise, 0)
+		add("$.sensors.beacon.scanInterval", b.ScanInterval, 0)
+		add("$.sensors.beacon.exitTimeout", b.ExitTimeout, 0)
+		add("$.sensors.beacon.missRate", b.MissRate, 1)
+	}
+	if g := s.Sensors.GPS; g != nil {
+		add("$.sensors.gps.noise", g.Noise, 0)
+		add("$.sensors.gps.fixInterval", g.FixInterval, 0)
+		add("$.sensors.gps.missRate", g.MissRate, 1)
+	}
+	return problems
+}
+
+// setupSensors fills in the sensor defaults and indexes how far away each beacon might be seen from
+func (s *State) setupSensors() {
+	sensors := s.scenario.Sensors
+	if sensors == nil {
+		return
+	}
+	if sensors.Beacon != nil {
+		b := sensors.Beacon.withDefaults()
+		sensors.Beacon = &b
+	}
+	if sensors.GPS != nil {
+		g := sensors.GPS.withDefaults()
+		sensors.GPS = &g
+	}
+	s.beaconGrid = make([][]int, s.width*s.height)
+	for i := range s.Regions {
+		r := &s.Regions[i]
+		if s.beaconSensed(r) {
+			reach := r.radius * sensors.Beacon.maxRange()
+			s.addRegionToGrid(s.beaconGrid, i, r.X-reach, r.Y-reach, r.X+reach, r.Y+reach)
+		}
+	}
+}
+
+func (s *State) sensing() bool {
+	return s.scenario.Sensors != nil
+}
+
+func (s *State) beaconSensed(r *Region) bool {
+	return r.Type == "beacon" && s.scenario.Sensors.Beacon != nil && r.radius > 0
+}
+
+func (s *State) gpsSensed(r *Region) bool {
+	return r.Type == "gps" && s.scenario.Sensors.GPS != nil
+}
+
+// phoneState is what an individual's phone believes about the regions around it
+type phoneState struct {
+	rand       *rand.Rand
+	inside     map[int32]bool
+	lastSeen   map[int32]time.Time // when each beacon was last seen
+	nextScan   time.Time
+	nextFix    time.Time
+	fixX, fixY float64 // the last GPS fix, in tiles
+	hasFix     bool
+}
+
+// phone is made the first time it is needed, with its own random stream so turning sensors on doesn't change how
+// anybody moves
+func (i *Individual) phone(s *State) *phoneState {
+	if i.sensors != nil {
+		return i.sensors
+	}
+	h := fnv.New64a()
+	h.Write([]byte(i.UUID))
+	st := &phoneState{
+		rand:     rand.New(rand.NewSource(s.Seed ^ int64(h.Sum64()))),
+		inside:   make(map[int32]bool),
+		lastSeen: make(map[int32]time.Time),
+	}
+	// phones don't scan or fix in step with each other
+	if b := s.scenario.Sensors.Beacon; b != nil {
+		st.nextScan = s.time.Add(time.Duration(st.rand.Float64() * b.ScanInterval * float64(time.Second)))
+	}
+	if g := s.scenario.Sensors.GPS; g != nil {
+		st.nextFix = s.time.Add(time.Duration(st.rand.Float64() * g.FixInterval * float64(time.Second)))
+	}
+	i.sensors = st
+	return st
+}
+
+// SenseRegions runs the phone of every update sender for this tick, sending an update whenever what a phone
+// believes changes
+func (s *State) SenseRegions() {
+	if !s.sensing() {
+		return
+	}
+	for _, p := range s.allPeople {
+		if p.UpdateSender {
+			s.sense(p)
+		}
+	}
+}
+
+func (s *State) sense(p *Individual) {
+	st := p.phone(s)
+	sensors := s.scenario.Sensors
+	x, y := p.Loc.GetLatestXY()
+
+	fixed := false
+	if g := sensors.GPS; g != nil && !s.time.Before(st.nextFix) {
+		st.nextFix = st.nextFix.Add(time.Duration(g.FixInterval * float64(time.Second)))
+		if st.rand.Float64() >= g.MissRate {
+			noise := s.metresToTiles(g.Noise)
+			st.fixX = x + st.rand.NormFloat64()*noise
+			st.fixY = y + st.rand.NormFloat64()*noise
+			st.hasFix = true
+			fixed = true
+		}
+	}
+	scanned := false
+	if b := sensors.Beacon; b != nil && !s.time.Before(st.nextScan) {
+		st.nextScan = st.nextScan.Add(time.Duration(b.ScanInterval * float64(time.Second)))
+		scanned = true
+	}
+
+	for _, i := range s.sensorCandidates(st, x, y) {
+		r := &s.Regions[i]
+		switch {
+		case s.beaconSensed(r):
+			b := sensors.Beacon
+			if scanned {
+				d := math.Hypot(x-r.X, y-r.Y) * s.metresPerTile
+				rssi := b.rssi(d) + st.rand.NormFloat64()*b.Noise
+				if rssi >= b.rssi(r.radius*s.metresPerTile) && st.rand.Float64() >= b.MissRate {
+					st.lastSeen[r.ID] = s.time
+					if !st.inside[r.ID] {
+						s.sensedChange(p, r, true, r.X, r.Y, b.distance(rssi))
+					}
+				}
+			}
+			if st.inside[r.ID] && s.time.Sub(st.lastSeen[r.ID]).Seconds() >= b.ExitTimeout {
+				s.sensedChange(p, r, false, r.X, r.Y, 0)
+			}
+		case s.gpsSensed(r):
+			if fixed && r.Contains(st.fixX, st.fixY) != st.inside[r.ID] {
+				s.sensedChange(p, r, !st.inside[r.ID], st.fixX, st.fixY, sensors.GPS.Noise)
+			}
+		default:
+			if p.RegionIds[r.ID] != st.inside[r.ID] {
+				s.sensedChange(p, r, p.RegionIds[r.ID], x, y, 0)
+			}
+		}
+	}
+}
+
+// sensorCandidates is, in region order, every region the phone might notice entering or leaving this tick
+func (s *State) sensorCandidates(st *phoneState, x, y float64) []int {
+	var candidates []int
+	cell := func(grid [][]int, x, y float64) {
+		tx, ty := int(math.Floor(x)), int(math.Floor(y))
+		if tx >= 0 && tx < s.width && ty >= 0 && ty < s.height {
+			candidates = append(candidates, grid[tx*s.height+ty]...)
+		}
+	}
+	cell(s.regionGrid, x, y)
+	cell(s.beaconGrid, x, y)
+	if st.hasFix {
+		cell(s.regionGrid, st.fixX, st.fixY)
+	}
+	for id, in := range st.inside {
+		if i, ok := s.regionIndex[id]; in && ok {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.Ints(candidates)
+	unique := candidates[:0]
+	for j, i := range candidates {
+		if j == 0 || i != candidates[j-1] {
+			unique = append(unique, i)
+		}
+	}
+	return unique
+}
+
+// sensedChange sends an update for a region the phone now believes it has entered or left, from the position x, y
+// in tiles it believes it is at, accurate to within accuracy metres if that is known
+func (s *State) sensedChange(p *Individual, r *Region, entering bool, x, y, accuracy float64) {
+	st := p.phone(s)
+	st.inside[r.ID] = entering
+	s.recordSensedEvent(p, r, entering)
+	if s.sendsDebounced() {
+		return
+	}
+	u := update{EventID: r.EventID, RegionID: r.ID, UUID: p.UUID, Entering: entering, OccurredAt: s.time.Unix()}
+	if s.scenario.extendedUpdates() {
+		u.Lat, u.Lng, _ = s.TileToLatLng(x, y)
+		u.Accuracy = accuracy
+	}
+	s.queueUpdate(p, u, s.BulkSend)
+}
+
+// leaveSensedRegions sends a leave for every region the phone believes it is in, for when its owner leaves the site
+func (s *State) leaveSensedRegions(p *Individual) {
+	st := p.phone(s)
+	ids := make([]int, 0, len(st.inside))
+	for id, in := range st.inside {
+		if in {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+	x, y := p.Loc.GetLatestXY()
+	for _, id := range ids {
+		s.sensedChange(p, s.FindRegion(int32(id)), false, x, y, 0)
+	}
+}
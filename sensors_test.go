@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+)
+
+// sensorTestWorld is 30 metres square with a beacon region, a GPS region and a region with no sensor, each of radius
+// 5 metres. The scenario debounces so sensed changes are only recorded on the phone rather than sent.
+func sensorTestWorld(sensors *Sensors) *State {
+	rows := make([]string, 30)
+	for i := range rows {
+		rows[i] = strings.Repeat(".", 30)
+	}
+	w := gridWorld(rows...)
+	w.scenario.Sensors = sensors
+	w.scenario.Debounce = &Debounce{}
+	w.time = time.Date(2018, 11, 23, 7, 0, 0, 0, time.UTC)
+	w.Regions = []Region{
+		{ID: 1, Type: "beacon", X: 7, Y: 7, radius: 5, sqRad: 25},
+		{ID: 2, Type: "gps", X: 22, Y: 7, radius: 5, sqRad: 25},
+		{ID: 3, Type: "wifi", X: 7, Y: 22, radius: 5, sqRad: 25},
+	}
+	w.indexRegions()
+	w.setupSensors()
+	return w
+}
+
+// senseFor moves p to x, y and runs their phone every second until inside region id is want, returning how many
+// seconds that took, or -1 if it didn't happen within limit seconds
+func senseFor(w *State, p *Individual, x, y float64, id int32, want bool, limit int) int {
+	p.Loc = geometry.NewPoint(x, y)
+	p.RegionIds = make(map[int32]bool)
+	for _, i := range w.regionsToCheck(p, x, y) {
+		if w.Regions[i].Contains(x, y) {
+			p.RegionIds[w.Regions[i].ID] = true
+		}
+	}
+	for s := 0; s <= limit; s++ {
+		w.sense(p)
+		if p.phone(w).inside[id] == want {
+			return s
+		}
+		w.time = w.time.Add(time.Second)
+	}
+	return -1
+}
+
+func TestBeaconRange(t *testing.T) {
+	b := BeaconSensor{}.withDefaults()
+	for _, d := range []float64{0.5, 1, 5, 20} {
+		if got := b.distance(b.rssi(d)); math.Abs(got-d) > 1e-9 {
+			t.Errorf("a beacon %vm away is estimated to be %vm away", d, got)
+		}
+	}
+	if b.rssi(1) != b.TxPower {
+		t.Errorf("strength at a metre is %v, want the tx power %v", b.rssi(1), b.TxPower)
+	}
+}
+
+func TestBeaconSensing(t *testing.T) {
+	w := sensorTestWorld(&Sensors{Beacon: &BeaconSensor{Noise: 0.01, ExitTimeout: 30}})
+	p := &Individual{UUID: "a"}
+	if s := senseFor(w, p, 7.5, 7.5, 1, true, 2); s < 0 {
+		t.Fatal("the beacon wasn't seen from beside it")
+	}
+	// out of range the beacon is left once it has gone unseen for the exit timeout, and not before
+	if s := senseFor(w, p, 7.5, 14, 1, false, 40); s < 29 || s > 31 {
+		t.Errorf("left the beacon %d seconds after walking away, want the 30 second exit timeout", s)
+	}
+}
+
+func TestBeaconEdge(t *testing.T) {
+	// with noise, a phone on the edge of a beacon's region sees it about half the time
+	w := sensorTestWorld(&Sensors{Beacon: &BeaconSensor{}})
+	seen := 0
+	for i := 0; i < 400; i++ {
+		p := &Individual{UUID: strconv.Itoa(i)}
+		p.Loc = geometry.NewPoint(12, 7)
+		w.sense(p)
+		w.time = w.time.Add(time.Second)
+		w.sense(p)
+		if p.phone(w).inside[1] {
+			seen++
+		}
+	}
+	if seen < 120 || seen > 280 {
+		t.Errorf("%d of 400 phones on the edge saw the beacon, want about half", seen)
+	}
+}
+
+func TestGPSSensing(t *testing.T) {
+	w := sensorTestWorld(&Sensors{GPS: &GPSSensor{Noise: 0.01, FixInterval: 5}})
+	p := &Individual{UUID: "a"}
+	if s := senseFor(w, p, 22.5, 7.5, 2, true, 5); s < 0 {
+		t.Fatal("didn't get a fix inside the region within a fix interval")
+	}
+	st := p.phone(w)
+	if math.Hypot(st.fixX-22.5, st.fixY-7.5) > 0.1 {
+		t.Errorf("fix is at %v, %v, far from 22.5, 7.5", st.fixX, st.fixY)
+	}
+	// leaving is only noticed at the next fix
+	w.time = w.time.Add(time.Second)
+	if s := senseFor(w, p, 22.5, 14, 2, false, 5); s < 3 || s > 4 {
+		t.Errorf("noticed leaving %d seconds after the next second, want at the fix 4 seconds later", s)
+	}
+}
+
+func TestUnsensedRegion(t *testing.T) {
+	w := sensorTestWorld(&Sensors{Beacon: &BeaconSensor{}, GPS: &GPSSensor{}})
+	p := &Individual{UUID: "a"}
+	if s := senseFor(w, p, 7.5, 22.5, 3, true, 0); s != 0 {
+		t.Error("a region with no sensor wasn't entered straight away")
+	}
+	if s := senseFor(w, p, 7.5, 28, 3, false, 0); s != 0 {
+		t.Error("a region with no sensor wasn't left straight away")
+	}
+}
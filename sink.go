@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBackendURL = "http://api.jackchorley.club"
+
+// defaultTimeout stops a backend which has stopped responding from holding up every sender for ever
+const defaultTimeout = 10 * time.Second
+
+// UpdateSink is where region updates end up once they leave the simulation
+type UpdateSink interface {
+	Send(u *update) error
+	SendBulk(updates []update) error
+	Close() error
+}
+
+type SinkConfig struct {
+	Type      string            `json:"type"` // one of http, file, stdout or none
+	URL       string            `json:"url,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	TimeoutMs int               `json:"timeoutMs,omitempty"` // 10 seconds if 0
+}
+
+// ParseSinkSpec turns a command line sink description into a SinkConfig. Accepted forms are
+// "none", "stdout", "file:<path>" and an http(s) base URL.
+func ParseSinkSpec(spec string) (SinkConfig, error) {
+	switch {
+	case spec == "none" || spec == "stdout":
+		return SinkConfig{Type: spec}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return SinkConfig{Type: "file", Path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return SinkConfig{Type: "http", URL: spec}, nil
+	}
+	return SinkConfig{}, fmt.Errorf("unknown sink %q", spec)
+}
+
+func NewUpdateSink(config SinkConfig) (UpdateSink, error) {
+	switch config.Type {
+	case "", "none":
+		return NoopSink{}, nil
+	case "stdout":
+		return NewWriterSink(os.Stdout, nil), nil
+	case "file":
+		if config.Path == "" {
+			return nil, fmt.Errorf("file sink needs a path")
+		}
+		file, err := os.Create(config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterSink(file, file), nil
+	case "http":
+		return NewHTTPSink(config), nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", config.Type)
+}
+
+// HTTPSink posts updates to an rtfa backend
+type HTTPSink struct {
+	BaseURL string
+	Headers map[string]string
+	client  *http.Client
+}
+
+func NewHTTPSink(config SinkConfig) *HTTPSink {
+	base := config.URL
+	if base == "" {
+		base = defaultBackendURL
+	}
+	timeout := time.Duration(config.TimeoutMs) * time.Millisecond
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &HTTPSink{
+		BaseURL: strings.TrimSuffix(base, "/"),
+		Headers: config.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSink) Send(u *update) error {
+	return s.post("/update", *u)
+}
+
+func (s *HTTPSink) SendBulk(updates []update) error {
+	return s.post("/bulkUpdate", updates)
+}
+
+func (s *HTTPSink) post(path string, body interface{}) error {
+	jsonStr, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.BaseURL+path, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	err = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("backend responded %s", resp.Status)
+	}
+	return err
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// WriterSink writes each update as a line of JSON
+type WriterSink struct {
+	lock   sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+func NewWriterSink(w io.Writer, closer io.Closer) *WriterSink {
+	return &WriterSink{w: bufio.NewWriter(w), closer: closer}
+}
+
+func (s *WriterSink) Send(u *update) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.write(u)
+}
+
+func (s *WriterSink) SendBulk(updates []update) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range updates {
+		if err := s.write(&updates[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WriterSink) write(u *update) error {
+	if s.w == nil {
+		return fmt.Errorf("sink is closed")
+	}
+	jsonStr, err := json.Marshal(*u)
+	if err != nil {
+		return err
+	}
+	jsonStr = append(jsonStr, '\n')
+	_, err = s.w.Write(jsonStr)
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	s.w = nil
+	if s.closer != nil {
+		if cerr := s.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// NoopSink drops every update
+type NoopSink struct{}
+
+func (NoopSink) Send(u *update) error {
+	return nil
+}
+
+func (NoopSink) SendBulk(updates []update) error {
+	return nil
+}
+
+func (NoopSink) Close() error {
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseSinkSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want SinkConfig
+	}{
+		{"none", SinkConfig{Type: "none"}},
+		{"stdout", SinkConfig{Type: "stdout"}},
+		{"file:out/updates.json", SinkConfig{Type: "file", Path: "out/updates.json"}},
+		{"http://localhost:8080", SinkConfig{Type: "http", URL: "http://localhost:8080"}},
+		{"https://example.com/api", SinkConfig{Type: "http", URL: "https://example.com/api"}},
+	}
+	for _, test := range tests {
+		got, err := ParseSinkSpec(test.spec)
+		if err != nil {
+			t.Errorf("ParseSinkSpec(%q): %s", test.spec, err)
+			continue
+		}
+		if got.Type != test.want.Type || got.Path != test.want.Path || got.URL != test.want.URL {
+			t.Errorf("ParseSinkSpec(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+	for _, spec := range []string{"", "file", "ftp://example.com", "localhost:8080"} {
+		if _, err := ParseSinkSpec(spec); err == nil {
+			t.Errorf("ParseSinkSpec(%q) accepted it", spec)
+		}
+	}
+}
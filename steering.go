@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	legacySteeringModel = "legacy"
+	socialForceModel    = "socialForce"
+)
+
+// Steering turns the way someone wants to go into how they move, given who and what is around them
+type Steering interface {
+	// Sways is whether people wander either side of the way they want to go, to stop them walking in straight lines
+	Sways() bool
+	// Move moves someone up to step tiles, heading for theta
+	Move(w *State, p *Individual, theta, step float64)
+}
+
+// SteeringConfig chooses the scenario's steering model. The legacy model walks straight for the heading, swaying to
+// either side, and stops short of anyone in the way. The social force model (Helbing and Molnár) has people keep
+// something of their last step and be pushed away from others and from walls as they near them, so they steer around
+// each other and fall into lanes. The settings are for the social force model.
+type SteeringConfig struct {
+	Model        string   `json:"model,omitempty"`        // legacy (the default) or socialForce
+	Strength     float64  `json:"strength,omitempty"`     // push from someone touching, as a fraction of a step, 1 by default
+	Range        float64  `json:"range,omitempty"`        // metres over which the push falls off, 0.3 by default
+	WallStrength float64  `json:"wallStrength,omitempty"` // push from a wall touching, as a fraction of a step, 1 by default
+	WallRange    float64  `json:"wallRange,omitempty"`    // metres, 0.2 by default
+	Relaxation   float64  `json:"relaxation,omitempty"`   // seconds to get back to the heading, at least a tick, 2 by default
+	Anisotropy   *float64 `json:"anisotropy,omitempty"`   // how much people behind are felt compared to those ahead, 0 to 1, 0.5 if not given
+	Cutoff       float64  `json:"cutoff,omitempty"`       // metres beyond which people and walls are ignored, 2 by default
+}
+
+func (s *State) validateSteering() []ValidationError {
+	var problems []ValidationError
+	c := s.scenario.Steering
+	if c == nil {
+		return problems
+	}
+	if c.Model != "" && c.Model != legacySteeringModel && c.Model != socialForceModel {
+		problems = append(problems, ValidationError{"$.steering.model", fmt.Sprintf("%q is not %s or %s", c.Model, legacySteeringModel, socialForceModel)})
+	}
+	if c.Strength < 0 || c.Range < 0 || c.WallStrength < 0 || c.WallRange < 0 || c.Cutoff < 0 {
+		problems = append(problems, ValidationError{"$.steering", "strengths, ranges and cutoff must not be negative"})
+	}
+	if c.Relaxation != 0 && c.Relaxation < 1 {
+		problems = append(problems, ValidationError{"$.steering.relaxation", "must be at least a tick (1 second)"})
+	}
+	if c.Anisotropy != nil && (*c.Anisotropy < 0 || *c.Anisotropy > 1) {
+		problems = append(problems, ValidationError{"$.steering.anisotropy", "must be between 0 and 1"})
+	}
+	return problems
+}
+
+// setupSteering builds the scenario's steering model, with its lengths in tiles
+func (s *State) setupSteering() {
+	c := SteeringConfig{Model: legacySteeringModel}
+	if s.scenario.Steering != nil {
+		c = *s.scenario.Steering
+	}
+	if c.Model != socialForceModel {
+		s.steering = legacySteering{}
+		return
+	}
+	f := socialForce{
+		strength:     c.Strength,
+		rng:          s.metresToTiles(c.Range),
+		wallStrength: c.WallStrength,
+		wallRange:    s.metresToTiles(c.WallRange),
+		relaxation:   c.Relaxation,
+		anisotropy:   0.5,
+		cutoff:       s.metresToTiles(c.Cutoff),
+	}
+	if c.Strength == 0 {
+		f.strength = 1
+	}
+	if c.Range == 0 {
+		f.rng = s.metresToTiles(0.3)
+	}
+	if c.WallStrength == 0 {
+		f.wallStrength = 1
+	}
+	if c.WallRange == 0 {
+		f.wallRange = s.metresToTiles(0.2)
+	}
+	if c.Relaxation == 0 {
+		f.relaxation = 2
+	}
+	// 0 is people only feeling those ahead of them, so it can't stand for the default
+	if c.Anisotropy != nil {
+		f.anisotropy = *c.Anisotropy
+	}
+	if c.Cutoff == 0 {
+		f.cutoff = s.metresToTiles(2)
+	}
+	s.steering = f
+}
+
+type legacySteering struct{}
+
+func (legacySteering) Sways() bool {
+	return true
+}
+
+func (legacySteering) Move(w *State, p *Individual, theta, step float64) {
+	w.MoveIndividual(p, theta, step)
+}
+
+type socialForce struct {
+	strength     float64
+	rng          float64 // tiles
+	wallStrength float64
+	wallRange    float64 // tiles
+	relaxation   float64 // ticks
+	anisotropy   float64
+	cutoff       float64 // tiles
+}
+
+func (socialForce) Sways() bool {
+	return false
+}
+
+// Move relaxes the individual's last step towards a full step along theta and adds the pushes of those around them,
+// never stepping further than step. Anyone still in the way stops them short as in the legacy model.
+func (f socialForce) Move(w *State, p *Individual, theta, step float64) {
+	x, y := p.Loc.GetLatestXY()
+	ex, ey := math.Cos(theta), math.Sin(theta)
+	vx := p.vx + (step*ex-p.vx)/f.relaxation
+	vy := p.vy + (step*ey-p.vy)/f.relaxation
+
+	reach := int(math.Ceil(f.cutoff))
+	for tx := int(x) - reach; tx <= int(x)+reach; tx++ {
+		for ty := int(y) - reach; ty <= int(y)+reach; ty++ {
+			tile := w.GetTile(tx, ty)
+			if !tile.Walkable() {
+				// push away from the nearest point of the wall
+				nx := math.Max(float64(tx), math.Min(float64(tx+1), x))
+				ny := math.Max(float64(ty), math.Min(float64(ty+1), y))
+				d := math.Hypot(x-nx, y-ny)
+				if d > 0 && d < f.cutoff {
+					push := f.wallStrength * step * math.Exp((p.radius-d)/f.wallRange)
+					vx += push * (x - nx) / d
+					vy += push * (y - ny) / d
+				}
+				continue
+			}
+			for _, other := range tile.People {
+				if other == p {
+					continue
+				}
+				ox, oy := other.Loc.GetLatestXY()
+				d := math.Hypot(x-ox, y-oy)
+				if d == 0 || d >= f.cutoff {
+					continue
+				}
+				nx, ny := (x-ox)/d, (y-oy)/d
+				// people ahead matter more than those behind
+				cos := -(nx*ex + ny*ey)
+				weight := f.anisotropy + (1-f.anisotropy)*(1+cos)/2
+				push := weight * f.strength * step * math.Exp((p.radius+other.radius-d)/f.rng)
+				vx += push * nx
+				vy += push * ny
+			}
+		}
+	}
+
+	distance := math.Hypot(vx, vy)
+	if distance > step {
+		vx, vy = vx*step/distance, vy*step/distance
+		distance = step
+	}
+	if distance == 0 {
+		w.MoveIndividual(p, theta, 0)
+	} else {
+		w.MoveIndividual(p, math.Atan2(vy, vx), distance)
+	}
+	nx, ny := p.Loc.GetLatestXY()
+	p.vx, p.vy = nx-x, ny-y
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+)
+
+// socialForceWorld is 11 metres square, walled along the top, using the social force model with its defaults
+func socialForceWorld() *State {
+	rows := []string{strings.Repeat("#", 11)}
+	for i := 1; i < 11; i++ {
+		rows = append(rows, strings.Repeat(".", 11))
+	}
+	w := gridWorld(rows...)
+	w.scenario.Steering = &SteeringConfig{Model: socialForceModel}
+	w.setupSteering()
+	w.maxRadius = 0.2
+	return w
+}
+
+// stand puts someone of radius 0.2 at x, y
+func stand(w *State, uuid string, x, y float64) *Individual {
+	p := &Individual{UUID: uuid, Loc: geometry.NewPoint(x, y), radius: 0.2}
+	tile := w.GetTile(int(x), int(y))
+	tile.People = append(tile.People, p)
+	return p
+}
+
+func TestSteeringModel(t *testing.T) {
+	w := socialForceWorld()
+	w.scenario.Steering = nil
+	w.setupSteering()
+	if _, ok := w.steering.(legacySteering); !ok || !w.steering.Sways() {
+		t.Errorf("without steering the model is %T, want the legacy one, which sways", w.steering)
+	}
+	w = socialForceWorld()
+	f, ok := w.steering.(socialForce)
+	if !ok || f.Sways() {
+		t.Fatalf("the model is %T, want social force, which doesn't sway", w.steering)
+	}
+	if f.strength != 1 || f.rng != 0.3 || f.wallRange != 0.2 || f.relaxation != 2 || f.anisotropy != 0.5 || f.cutoff != 2 {
+		t.Errorf("defaults are %+v", f)
+	}
+	none := 0.0
+	w.scenario.Steering.Anisotropy = &none
+	w.setupSteering()
+	if f := w.steering.(socialForce); f.anisotropy != 0 {
+		t.Errorf("anisotropy set to 0 is %v", f.anisotropy)
+	}
+}
+
+func TestSocialForceRelaxation(t *testing.T) {
+	// from standing, someone alone speeds up towards a full step, halving the difference each tick
+	w := socialForceWorld()
+	p := stand(w, "a", 4.5, 5.5)
+	for i, want := range []float64{0.2, 0.3, 0.35, 0.375} {
+		x, _ := p.Loc.GetLatestXY()
+		w.steering.Move(w, p, 0, 0.4)
+		// an even number of ticks, leaving the tick as other tests expect it
+		geometry.FlipTick()
+		nx, ny := p.Loc.GetLatestXY()
+		if math.Abs(nx-x-want) > 1e-9 || ny != 5.5 {
+			t.Errorf("step %d went from %v to (%v, %v), want %v along", i, x, nx, ny, want)
+		}
+	}
+}
+
+// sidestep is how far to the side someone at 3.5, 5.5 heading along x steps on their first tick, with someone else
+// at ox, oy
+func sidestep(ox, oy float64) float64 {
+	w := socialForceWorld()
+	p := stand(w, "a", 3.5, 5.5)
+	stand(w, "b", ox, oy)
+	w.steering.Move(w, p, 0, 0.4)
+	x, y := p.Loc.GetLatestXY()
+	if math.Hypot(x-3.5, y-5.5) > 0.4+1e-9 {
+		return math.NaN()
+	}
+	return y - 5.5
+}
+
+func TestSocialForcePushesApart(t *testing.T) {
+	ahead, behind := sidestep(4.5, 5.4), sidestep(2.5, 5.4)
+	if math.IsNaN(ahead) || math.IsNaN(behind) {
+		t.Fatal("stepped further than a step")
+	}
+	if ahead <= 0 || behind <= 0 {
+		t.Fatalf("stepped %v and %v to the side, want away from the other person", ahead, behind)
+	}
+	if ahead <= behind {
+		t.Errorf("stepped %v aside from someone ahead and %v from someone behind, want more for ahead", ahead, behind)
+	}
+	if far := sidestep(6.5, 5.4); far != 0 {
+		t.Errorf("stepped %v aside from someone beyond the cutoff", far)
+	}
+}
+
+func TestSocialForcePushesFromWalls(t *testing.T) {
+	w := socialForceWorld()
+	p := stand(w, "a", 3.5, 1.25)
+	w.steering.Move(w, p, 0, 0.4)
+	if _, y := p.Loc.GetLatestXY(); y <= 1.25 {
+		t.Errorf("walking along the wall went to y = %v, want away from it", y)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// TerrainClass gives every tile of one colour of the map a cost to walk across and a speed to walk at, so paths can
+// be told apart from grass, mud or stairs
+type TerrainClass struct {
+	Name   string  `json:"name"`
+	Colour string  `json:"colour"` // #rrggbb
+	Cost   float64 `json:"cost"`   // per tile walked, relative to the 1 of any colour not in the palette
+	Speed  float64 `json:"speed"`  // fraction of normal walking speed
+}
+
+// parseColour reads a #rrggbb colour
+func parseColour(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("%q is not a #rrggbb colour", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("%q is not a #rrggbb colour", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// validateTerrain checks the scenario's terrain palette
+func (s *Scenario) validateTerrain() []ValidationError {
+	var problems []ValidationError
+	colours := make(map[color.RGBA]string)
+	for i, t := range s.Terrain {
+		path := fmt.Sprintf("$.terrain[%d]", i)
+		c, err := parseColour(t.Colour)
+		if err != nil {
+			problems = append(problems, ValidationError{path + ".colour", err.Error()})
+		} else if !walkable(c) {
+			problems = append(problems, ValidationError{path + ".colour", "black is used for walls"})
+		} else if other, ok := colours[c]; ok {
+			problems = append(problems, ValidationError{path + ".colour", fmt.Sprintf("%s is also used by %s", t.Colour, other)})
+		} else {
+			colours[c] = path
+		}
+		if t.Cost <= 0 {
+			problems = append(problems, ValidationError{path + ".cost", "must be positive"})
+		}
+		if t.Speed <= 0 {
+			problems = append(problems, ValidationError{path + ".speed", "must be positive"})
+		}
+	}
+	return problems
+}
+
+// applyTerrain sets the cost and speed of every walkable tile whose colour is in the scenario's terrain palette
+func (s *State) applyTerrain() {
+	if len(s.scenario.Terrain) == 0 {
+		return
+	}
+	colours := make([]color.RGBA, len(s.scenario.Terrain))
+	for i, t := range s.scenario.Terrain {
+		colours[i], _ = parseColour(t.Colour)
+	}
+	for x := 0; x < s.width; x++ {
+		for y := 0; y < s.height; y++ {
+			tile := &s.tiles[x][y]
+			if !tile.walkable {
+				continue
+			}
+			c := s.background.At(x, y)
+			for i, t := range s.scenario.Terrain {
+				if sameColour(colours[i], c) {
+					tile.cost = t.Cost
+					tile.speed = t.Speed
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+)
+
+var grass = TerrainClass{Name: "grass", Colour: "#00c800", Cost: 3, Speed: 0.5}
+
+func TestApplyTerrain(t *testing.T) {
+	w := gridWorld(
+		".g#",
+	)
+	w.scenario.Terrain = []TerrainClass{grass}
+	w.applyTerrain()
+	for _, c := range []struct {
+		x           int
+		cost, speed float64
+	}{{0, 1, 1}, {1, 3, 0.5}, {2, 1, 1}} {
+		tile := w.GetTile(c.x, 0)
+		if tile.Cost() != c.cost || tile.Speed() != c.speed {
+			t.Errorf("tile %d costs %v at speed %v, want %v at %v", c.x, tile.Cost(), tile.Speed(), c.cost, c.speed)
+		}
+	}
+}
+
+func TestTerrainCost(t *testing.T) {
+	initDeltas()
+	w := gridWorld(
+		".....",
+		".ggg.",
+		".....",
+	)
+	w.scenario.Terrain = []TerrainClass{grass}
+	w.applyTerrain()
+	field := w.computeFlowField(w.withDestination(0, 1))
+	// going round the grass is cheaper than the 10 it costs to cross it
+	if d, want := field.Dist(4, 1), 2+2*math.Sqrt2; math.Abs(d-want) > 1e-9 {
+		t.Errorf("distance from across the grass is %v, want %v round it", d, want)
+	}
+}
+
+func TestTerrainSpeed(t *testing.T) {
+	w := gridWorld(
+		".....",
+		".ggg.",
+		".....",
+	)
+	w.scenario.Terrain = []TerrainClass{grass}
+	w.applyTerrain()
+	for _, start := range []struct {
+		y, want float64
+	}{{0.5, 2.9}, {1.5, 2.7}} {
+		p := &Individual{Loc: geometry.NewPoint(2.5, start.y), radius: 0.2}
+		tile := w.GetTile(2, int(start.y))
+		tile.People = append(tile.People, p)
+		w.MoveIndividual(p, 0, 0.4)
+		if x, _ := p.Loc.GetLatestXY(); math.Abs(x-start.want) > 1e-9 {
+			t.Errorf("walking 0.4 from (2.5, %v) reached x = %v, want %v", start.y, x, start.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+)
+
+const (
+	traceFormat  = "rtfa-trace"
+	traceVersion = 1
+)
+
+// A trace is a JSON-lines file: one TraceHeader followed by one TraceTick per simulated second
+
+type TraceHeader struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Scenario  string `json:"scenario"`
+	Seed      int64  `json:"seed"`
+	Sensors   bool   `json:"sensors,omitempty"`   // senders' updates are the sensed events rather than the real ones
+	Debounced bool   `json:"debounced,omitempty"` // senders' updates are the debounced events
+}
+
+type TraceTick struct {
+	Time   int64         `json:"time"`
+	People []TracePerson `json:"people"`
+	Events []TraceEvent  `json:"events,omitempty"`
+	Waits  []TraceWait   `json:"waits,omitempty"`
+}
+
+type TracePerson struct {
+	UUID    string  `json:"id"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Target  int     `json:"dest,omitempty"`
+	Sender  bool    `json:"sender,omitempty"`
+	Persona string  `json:"persona,omitempty"`
+	Radius  float64 `json:"radius,omitempty"` // tiles
+}
+
+type TraceEvent struct {
+	UUID      string `json:"id"`
+	RegionID  int32  `json:"regionId"`
+	EventID   int32  `json:"eventId"`
+	Entering  bool   `json:"entering"`
+	Sender    bool   `json:"sender,omitempty"`
+	Sensed    bool   `json:"sensed,omitempty"`    // noticed by the sender's phone, which may be late or wrong
+	Debounced bool   `json:"debounced,omitempty"` // from the debounced stream rather than the raw one
+
+	// where the sender said they were, for the extended schema, so a replay sends exactly what the run did
+	Lat      float64 `json:"lat,omitempty"`
+	Lng      float64 `json:"lng,omitempty"`
+	Accuracy float64 `json:"accuracy,omitempty"`
+}
+
+// TraceWait is how long someone waited in the line for a destination, until they were let in or gave up
+type TraceWait struct {
+	UUID     string  `json:"id"`
+	RegionID int32   `json:"regionId,omitempty"` // the queue region, if the line has one
+	Dest     int     `json:"dest"`
+	Joined   int64   `json:"joined"`
+	Wait     float64 `json:"wait"` // seconds
+	Served   bool    `json:"served"`
+	Balked   bool    `json:"balked,omitempty"` // turned away from the line without joining it
+}
+
+type TraceRecorder struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	events  []TraceEvent
+	waits   []TraceWait
+}
+
+func NewTraceRecorder(path string, header TraceHeader) (*TraceRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	r := &TraceRecorder{file: file, writer: writer, encoder: json.NewEncoder(writer)}
+	header.Format = traceFormat
+	header.Version = traceVersion
+	err = r.encoder.Encode(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *TraceRecorder) RecordEvent(e TraceEvent) {
+	r.events = append(r.events, e)
+}
+
+// recordSent notes the position an extended update was sent with on the event it was sent for, which has just been
+// recorded in the stream senders send. For sensed and debounced events that is where the phone thought it was.
+func (r *TraceRecorder) recordSent(u update, sensed, debounced bool) {
+	for i := len(r.events) - 1; i >= 0; i-- {
+		e := &r.events[i]
+		if e.UUID == u.UUID && e.RegionID == u.RegionID && e.Entering == u.Entering && e.Sensed == sensed &&
+			e.Debounced == debounced {
+			e.Lat, e.Lng, e.Accuracy = u.Lat, u.Lng, u.Accuracy
+			return
+		}
+	}
+}
+
+// RecordTick writes where everyone is, and the region events since the last tick
+func (r *TraceRecorder) RecordTick(w *State) error {
+	tick := TraceTick{
+		Time:   w.time.Unix(),
+		People: make([]TracePerson, 0, len(w.allPeople)),
+		Events: r.events,
+		Waits:  r.waits,
+	}
+	for _, p := range w.allPeople {
+		x, y := p.Loc.GetLatestXY()
+		person := TracePerson{UUID: p.UUID, X: roundMillimetre(x), Y: roundMillimetre(y), Sender: p.UpdateSender, Persona: p.Persona,
+			Radius: roundMillimetre(p.radius)}
+		if p.target != nil {
+			person.Target = p.target.ID.ID
+		}
+		tick.People = append(tick.People, person)
+	}
+	r.events = nil
+	r.waits = nil
+	return r.encoder.Encode(tick)
+}
+
+func (r *TraceRecorder) Close() error {
+	err := r.writer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func roundMillimetre(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+func (s *State) recordRegionEvent(individual *Individual, r *Region, entering bool) {
+	if s.recorder == nil {
+		return
+	}
+	s.recorder.RecordEvent(TraceEvent{
+		UUID:     individual.UUID,
+		RegionID: r.ID,
+		EventID:  r.EventID,
+		Entering: entering,
+		Sender:   individual.UpdateSender,
+	})
+}
+
+func (s *State) recordSensedEvent(individual *Individual, r *Region, entering bool) {
+	if s.recorder == nil {
+		return
+	}
+	s.recorder.RecordEvent(TraceEvent{
+		UUID:     individual.UUID,
+		RegionID: r.ID,
+		EventID:  r.EventID,
+		Entering: entering,
+		Sender:   true,
+		Sensed:   true,
+	})
+}
+
+func (s *State) recordDebouncedEvent(individual *Individual, r *Region, entering bool) {
+	if s.recorder == nil {
+		return
+	}
+	s.recorder.RecordEvent(TraceEvent{
+		UUID:      individual.UUID,
+		RegionID:  r.ID,
+		EventID:   r.EventID,
+		Entering:  entering,
+		Sender:    true,
+		Sensed:    s.sensing(),
+		Debounced: true,
+	})
+}
+
+func (s *State) recordWait(individual *Individual, d *Destination, r *Region, joined time.Time, wait float64, served, balked bool) {
+	if s.recorder == nil {
+		return
+	}
+	w := TraceWait{
+		UUID:   individual.UUID,
+		Dest:   d.ID.ID,
+		Joined: joined.Unix(),
+		Wait:   wait,
+		Served: served,
+		Balked: balked,
+	}
+	if r != nil {
+		w.RegionID = r.ID
+	}
+	s.recorder.waits = append(s.recorder.waits, w)
+}
+
+func (s *State) StopRecording() {
+	if s.recorder == nil {
+		return
+	}
+	err := s.recorder.Close()
+	if err != nil {
+		log.Println("Error closing trace: ", err)
+	}
+	s.recorder = nil
+}
+
+func (s *State) recordTick() {
+	if s.recorder == nil {
+		return
+	}
+	err := s.recorder.RecordTick(s)
+	if err != nil {
+		log.Fatal("cannot write trace: ", err)
+	}
+}
+
+type TraceReader struct {
+	Header  TraceHeader
+	file    *os.File
+	decoder *json.Decoder
+}
+
+func OpenTrace(path string) (*TraceReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &TraceReader{file: file, decoder: json.NewDecoder(bufio.NewReader(file))}
+	err = r.decoder.Decode(&r.Header)
+	if err == nil && r.Header.Format != traceFormat {
+		err = fmt.Errorf("%s is not a trace file", path)
+	}
+	if err == nil && r.Header.Version != traceVersion {
+		err = fmt.Errorf("unsupported trace version %d", r.Header.Version)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Next returns the next tick, or io.EOF at the end of the trace
+func (r *TraceReader) Next() (*TraceTick, error) {
+	var tick TraceTick
+	err := r.decoder.Decode(&tick)
+	if err != nil {
+		return nil, err
+	}
+	return &tick, nil
+}
+
+func (r *TraceReader) Close() error {
+	return r.file.Close()
+}
+
+// Replay drives the renderer, if there is one, and the update sink from a trace without running any of the
+// movement or collision code. speed is how many simulated seconds pass per real second, 0 meaning as fast as possible.
+func Replay(world *State, trace *TraceReader, r *RenderState, speed float64) {
+	if r != nil {
+		<-world.playPauseChan
+	}
+	log.Println("Replay starting")
+
+	people := make(map[string]*Individual)
+	steps := 0
+	var last time.Time
+	for {
+		tick, err := trace.Next()
+		if err != nil {
+			break
+		}
+		t := time.Unix(tick.Time, 0).UTC()
+		if speed > 0 && !last.IsZero() {
+			time.Sleep(time.Duration(float64(t.Sub(last)) / speed))
+		}
+		last = t
+		world.time = t
+
+		for x := range world.tiles {
+			for y := range world.tiles[x] {
+				world.tiles[x][y].People = nil
+			}
+		}
+		world.peopleCurrent = len(tick.People)
+		for _, tp := range tick.People {
+			p, ok := people[tp.UUID]
+			if !ok {
+				p = world.addReplayedPerson(tp)
+				people[tp.UUID] = p
+			}
+			p.Loc = geometry.NewPoint(tp.X, tp.Y)
+			p.target = world.scenario.GetDestination(DestinationID{tp.Target})
+			tile := world.GetTileHighRes(tp.X, tp.Y)
+			if tile != nil {
+				tile.People = append(tile.People, p)
+			}
+		}
+
+		for _, e := range tick.Events {
+			p := people[e.UUID]
+			if p == nil || !p.UpdateSender || e.Sensed != trace.Header.Sensors ||
+				e.Debounced != trace.Header.Debounced {
+				continue
+			}
+			u := update{EventID: e.EventID, RegionID: e.RegionID, UUID: e.UUID, Entering: e.Entering, OccurredAt: tick.Time,
+				Lat: e.Lat, Lng: e.Lng, Accuracy: e.Accuracy}
+			world.queueUpdate(p, u, world.BulkSend)
+		}
+
+		if r != nil {
+			r.SendEvent(UpdateEvent{World: world})
+			world.peopleAddedChan <- world.peopleAdded
+			world.peopleCurrentChan <- world.peopleCurrent
+			world.simulationTimeChan <- world.time
+			world.currentSendersChan <- world.currentSenders
+			world.totalSendsChan <- GetTotalUpdates()
+		}
+		steps++
+		if steps%500 == 0 {
+			SendBulk()
+		}
+
+		select {
+		case <-world.playPauseChan:
+			<-world.playPauseChan
+		default:
+		}
+	}
+	log.Println("Replay finished at", world.time)
+}
+
+func (s *State) addReplayedPerson(tp TracePerson) *Individual {
+	r, g, b := color.YCbCrToRGB(uint8(100), uint8(s.rand.Intn(256)), uint8(s.rand.Intn(256)))
+	person := &Individual{
+		Loc:          geometry.NewPoint(tp.X, tp.Y),
+		Colour:       color.RGBA{r, g, b, 255},
+		UUID:         tp.UUID,
+		RegionIds:    make(map[int32]bool),
+		UpdateSender: tp.Sender,
+		Persona:      tp.Persona,
+		radius:       tp.Radius,
+	}
+	// traces from before people had sizes of their own
+	if person.radius == 0 {
+		person.radius = s.personRadius
+	}
+	if person.UpdateSender {
+		updateChan := UpdateChan{make(chan update, 50), make(chan bool)}
+		person.UpdateChan = &updateChan
+		startPersonalSender(&updateChan, s.Sink)
+		s.currentSenders++
+	}
+	s.peopleAdded++
+	s.allPeople = append(s.allPeople, person)
+	return person
+}
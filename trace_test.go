@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/mockbackend"
+)
+
+func TestReplaySendsWhatTheRunSent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scenario.json")
+	if err := ioutil.WriteFile(path, []byte(mockTestScenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	load := func() *State {
+		w, problems := ReadScenario(path)
+		if len(problems) > 0 {
+			t.Fatalf("scenario problems: %v", problems)
+		}
+		w.MakeChannes()
+		w.CacheDir = dir
+		w.OutputDir = dir
+		w.SetSeed(3)
+		w.maxSenders = 300
+		return w
+	}
+
+	tracePath := filepath.Join(dir, "trace.jsonl")
+	w := load()
+	recorded, sink := w.StartMockBackend()
+	w.StartSenders(sink)
+	var err error
+	w.recorder, err = NewTraceRecorder(tracePath, TraceHeader{Scenario: path, Seed: w.Seed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunHeadless(w)
+	if len(recorded.Updates()) == 0 {
+		t.Fatal("the run sent nothing")
+	}
+
+	trace, err := OpenTrace(tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trace.Close()
+	if trace.Header.Scenario != path || trace.Header.Seed != 3 {
+		t.Errorf("trace header is %+v", trace.Header)
+	}
+	w = load()
+	replayed, sink := w.StartMockBackend()
+	w.StartSenders(sink)
+	done := w.drainStats()
+	Replay(w, trace, nil, 0)
+	w.StopSenders()
+	close(done)
+
+	if diffs := mockbackend.Diff(recorded.Updates(), replayed.Updates()); len(diffs) != 0 {
+		t.Errorf("the replay sent %d updates and the run %d, differing by %q",
+			len(replayed.Updates()), len(recorded.Updates()), diffs)
+	}
+	if problems := replayed.Problems(); len(problems) != 0 {
+		t.Errorf("the replay's updates were inconsistent: %q", problems)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+const (
+	legacySchema   = "legacy"
+	extendedSchema = "extended"
+
+	defaultAccuracy = 5.0 // metres, about what a phone's GPS manages outdoors
+)
+
+// UpdateFormat chooses the schema of the updates sent to the backend. The legacy schema, the default, only says
+// which region was entered or left; the extended one adds where the person was, the accuracy of that position, the
+// region's type and a sequence number which counts up for each device.
+type UpdateFormat struct {
+	Schema   string  `json:"schema"`
+	Accuracy float64 `json:"accuracy,omitempty"` // metres, 5 if not given
+}
+
+// extendedUpdates is false when there is no scenario, as when replaying recorded updates which keep whatever
+// schema they were recorded with
+func (s *Scenario) extendedUpdates() bool {
+	return s != nil && s.UpdateFormat != nil && s.UpdateFormat.Schema == extendedSchema
+}
+
+func (s *State) validateUpdateFormat() []ValidationError {
+	f := s.scenario.UpdateFormat
+	if f == nil {
+		return nil
+	}
+	var problems []ValidationError
+	switch f.Schema {
+	case "", legacySchema:
+	case extendedSchema:
+		if s.geo == nil {
+			problems = append(problems, ValidationError{"$.updateFormat.schema", "extended updates need a georef, or lat and lng, to place people"})
+		}
+	default:
+		problems = append(problems, ValidationError{"$.updateFormat.schema", fmt.Sprintf("%q is not %s or %s", f.Schema, legacySchema, extendedSchema)})
+	}
+	if f.Accuracy < 0 {
+		problems = append(problems, ValidationError{"$.updateFormat.accuracy", "must not be negative"})
+	}
+	return problems
+}
+
+// extendUpdate fills in the extended fields of u, taking the position from where the individual is now unless a
+// sensor has already given one
+func (s *State) extendUpdate(individual *Individual, u *update) {
+	if u.Lat == 0 && u.Lng == 0 {
+		x, y := individual.Loc.GetLatestXY()
+		lat, lng, err := s.TileToLatLng(x, y)
+		if err == nil {
+			u.Lat, u.Lng = lat, lng
+		}
+	}
+	if u.Accuracy == 0 {
+		u.Accuracy = s.scenario.UpdateFormat.Accuracy
+	}
+	if u.Accuracy == 0 {
+		u.Accuracy = defaultAccuracy
+	}
+	if r := s.FindRegion(u.RegionID); r != nil {
+		u.RegionType = r.Type
+	}
+	individual.sequence++
+	u.Sequence = individual.sequence
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
+	"github.com/real-time-footfall-analysis/rtfa-simulation/mockbackend"
+)
+
+func TestLegacyUpdateJSON(t *testing.T) {
+	u := update{UUID: "a", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 1543000000}
+	data, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"uuid":"a","eventId":1,"regionId":2,"entering":true,"occurredAt":1543000000}`
+	if string(data) != want {
+		t.Errorf("legacy update is %s, want %s", data, want)
+	}
+}
+
+func TestExtendedUpdateRoundTrip(t *testing.T) {
+	// on the prime meridian, so lng is 0 and must still be sent
+	u := update{UUID: "a", EventID: 1, RegionID: 2, Entering: true, OccurredAt: 1543000000,
+		Lat: 51.477, Lng: 0, Accuracy: 5, RegionType: "gps", Sequence: 3}
+	data, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"uuid", "eventId", "regionId", "entering", "occurredAt", "lat", "lng", "accuracy", "regionType", "sequence"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("extended update %s has no %s", data, key)
+		}
+	}
+	if len(fields) != 10 {
+		t.Errorf("extended update %s has %d fields, want 10", data, len(fields))
+	}
+
+	var back update
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back != u {
+		t.Errorf("read back %+v, want %+v", back, u)
+	}
+	var received mockbackend.Update
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if received != mockbackend.Update(u) {
+		t.Errorf("the backend reads %+v, want %+v", received, u)
+	}
+}
+
+func TestExtendUpdate(t *testing.T) {
+	w := gridWorld(
+		"....",
+		"....",
+	)
+	w.scenario.UpdateFormat = &UpdateFormat{Schema: extendedSchema}
+	w.geo = originTransform(51.5, -0.17, 1)
+	w.Regions = []Region{{ID: 2, Type: "beacon", X: 1, Y: 1, radius: 1, sqRad: 1}}
+	w.indexRegions()
+	p := &Individual{Loc: geometry.NewPoint(2, 1)}
+
+	for sequence := int64(1); sequence <= 2; sequence++ {
+		u := update{UUID: "a", RegionID: 2, Entering: true, OccurredAt: 1543000000}
+		w.extendUpdate(p, &u)
+		lat, lng := w.geo.toLatLng(2, 1)
+		if u.Lat != lat || u.Lng != lng || u.Accuracy != defaultAccuracy || u.RegionType != "beacon" || u.Sequence != sequence {
+			t.Errorf("extended update is %+v, want %v, %v with accuracy %v, type beacon and sequence %d",
+				u, lat, lng, defaultAccuracy, sequence)
+		}
+	}
+
+	// a position and accuracy from a sensor are kept
+	w.scenario.UpdateFormat.Accuracy = 10
+	u := update{UUID: "a", RegionID: 2, OccurredAt: 1543000000, Lat: 51.6, Lng: -0.2, Accuracy: 20}
+	w.extendUpdate(p, &u)
+	if u.Lat != 51.6 || u.Lng != -0.2 || u.Accuracy != 20 {
+		t.Errorf("extending replaced the sensor's %v, %v and accuracy 20 with %v, %v and %v", 51.6, -0.2, u.Lat, u.Lng, u.Accuracy)
+	}
+}
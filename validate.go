@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError is one problem with a scenario, Path is a JSON path into the scenario file
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// parseScenario decodes a scenario file, checking every time in it can be parsed so they can all be reported at once
+func parseScenario(path string) (*Scenario, []ValidationError) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, []ValidationError{{"$", err.Error()}}
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, []ValidationError{{"$", describeJSONError(data, err)}}
+	}
+	var problems []ValidationError
+	top, _ := generic.(map[string]interface{})
+	checkTime(top, "start", "$", &problems)
+	checkTime(top, "end", "$", &problems)
+	if exit, ok := field(top, "exit").(map[string]interface{}); ok {
+		checkEventTimes(exit, "$.exit", &problems)
+	}
+	if dests, ok := field(top, "destinations").([]interface{}); ok {
+		for i, d := range dests {
+			if dest, ok := d.(map[string]interface{}); ok {
+				checkEventTimes(dest, fmt.Sprintf("$.Destinations[%d]", i), &problems)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	var scenario Scenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return nil, []ValidationError{{jsonPath(typeErr.Field), describeJSONError(data, err)}}
+		}
+		return nil, []ValidationError{{"$", describeJSONError(data, err)}}
+	}
+	return &scenario, nil
+}
+
+// jsonPath turns the dotted field of a decoding error, such as Destinations.0.meanUseTime, into a JSON path
+func jsonPath(field string) string {
+	path := "$"
+	for _, key := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(key); err == nil {
+			path += "[" + key + "]"
+		} else {
+			path += "." + key
+		}
+	}
+	return path
+}
+
+// field looks up a key the way encoding/json does, ignoring case
+func field(obj map[string]interface{}, key string) interface{} {
+	if v, ok := obj[key]; ok {
+		return v
+	}
+	for k, v := range obj {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+func checkTime(obj map[string]interface{}, key, path string, problems *[]ValidationError) {
+	v := field(obj, key)
+	if v == nil {
+		*problems = append(*problems, ValidationError{path + "." + key, "missing time"})
+		return
+	}
+	s, ok := v.(string)
+	if !ok {
+		*problems = append(*problems, ValidationError{path + "." + key, "time must be a string"})
+		return
+	}
+	if _, err := time.Parse(time.RFC3339, s); err != nil {
+		*problems = append(*problems, ValidationError{path + "." + key, fmt.Sprintf("%q is not an RFC 3339 time", s)})
+	}
+}
+
+func checkEventTimes(dest map[string]interface{}, path string, problems *[]ValidationError) {
+	events, _ := field(dest, "events").([]interface{})
+	for j, e := range events {
+		if event, ok := e.(map[string]interface{}); ok {
+			eventPath := fmt.Sprintf("%s.events[%d]", path, j)
+			checkTime(event, "start", eventPath, problems)
+			checkTime(event, "end", eventPath, problems)
+		}
+	}
+}
+
+func describeJSONError(data []byte, err error) string {
+	var offset int64 = -1
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return err.Error()
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	col := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Sprintf("%s (line %d, column %d)", err, line, col)
+}
+
+// validateScenario checks everything which needs the map and regions loaded
+func (s *State) validateScenario() []ValidationError {
+	var problems []ValidationError
+	add := func(path, format string, args ...interface{}) {
+		problems = append(problems, ValidationError{path, fmt.Sprintf(format, args...)})
+	}
+	scenario := s.scenario
+
+	if !scenario.Start.Before(scenario.End) {
+		add("$.end", "must be after start (%s)", scenario.Start)
+	}
+	if scenario.TotalPeople <= 0 {
+		add("$.totalPeople", "must be positive")
+	}
+	problems = append(problems, scenario.validateGroups()...)
+	problems = append(problems, s.validatePeople()...)
+	problems = append(problems, s.validateCrowding()...)
+	problems = append(problems, s.validateSteering()...)
+	problems = append(problems, scenario.validateTerrain()...)
+	problems = append(problems, s.validateAreas()...)
+	problems = append(problems, s.validateUpdateFormat()...)
+	problems = append(problems, scenario.validateSensors()...)
+	problems = append(problems, s.validateDebounce()...)
+	problems = append(problems, s.validateQueues()...)
+	if len(scenario.Entrances) == 0 {
+		add("$.entrance", "at least one entrance is needed")
+	}
+
+	entrances := make([]*Tile, 0)
+	for i, e := range scenario.Entrances {
+		path := fmt.Sprintf("$.entrance[%d]", i)
+		tile := s.GetTile(e.X, e.Y)
+		if tile == nil {
+			add(path, "(%d, %d) is outside the %dx%d map", e.X, e.Y, s.width, s.height)
+		} else if !tile.Walkable() {
+			add(path, "(%d, %d) is on a wall, nobody can be added here", e.X, e.Y)
+		} else {
+			entrances = append(entrances, tile)
+		}
+	}
+
+	type namedDest struct {
+		path string
+		dest *Destination
+	}
+	dests := make([]namedDest, 0)
+	for i := range scenario.Destinations {
+		dests = append(dests, namedDest{fmt.Sprintf("$.Destinations[%d]", i), &scenario.Destinations[i]})
+	}
+	dests = append(dests, namedDest{"$.exit", &scenario.Exit})
+
+	filenames := make(map[string]string)
+	for _, nd := range dests {
+		path, d := nd.path, nd.dest
+
+		if d.Name == "" {
+			add(path+".name", "missing name")
+		}
+		filename := strings.Replace(d.Name, " ", "-", -1)
+		if other, ok := filenames[filename]; ok {
+			add(path+".name", "flow field file %s.png is also used by %s", filename, other)
+		} else {
+			filenames[filename] = path
+		}
+
+		for j, e := range d.Events {
+			eventPath := fmt.Sprintf("%s.events[%d]", path, j)
+			if !e.Start.Before(e.End) {
+				add(eventPath+".end", "must be after start (%s)", e.Start)
+			}
+			if e.Start.Before(scenario.Start) || e.Start.After(scenario.End) {
+				add(eventPath+".start", "%s is outside the scenario's %s to %s", e.Start, scenario.Start, scenario.End)
+			}
+			if e.End.Before(scenario.Start) || e.End.After(scenario.End) {
+				add(eventPath+".end", "%s is outside the scenario's %s to %s", e.End, scenario.Start, scenario.End)
+			}
+		}
+
+		sources := make([]*Tile, 0)
+		sourcesValid := true
+		if d.RegionID > 0 {
+			region := s.FindRegion(d.RegionID)
+			if region == nil {
+				add(path+".regionId", "region %d is not in %s", d.RegionID, scenario.RegionsFile)
+				sourcesValid = false
+			} else if tiles := s.regionTiles(region); region.Radius == 0 && tiles.Len() > 0 {
+				sources = append(sources, s.areaTiles(tiles)...)
+			} else if tile := s.GetTile(int(region.X), int(region.Y)); !tile.Walkable() {
+				add(path+".regionId", "the centre of region %d (%s) at (%d, %d) is on a wall or off the map",
+					region.ID, region.Name, int(region.X), int(region.Y))
+				sourcesValid = false
+			} else {
+				sources = append(sources, tile)
+			}
+		}
+		if len(d.Coords) == 0 && d.RegionID <= 0 && d.Colour == "" {
+			add(path, "has neither coords, a regionId nor a colour")
+			sourcesValid = false
+		}
+		sources = append(sources, s.areaTiles(&d.area)...)
+		for k, c := range d.Coords {
+			tile := s.GetTile(c.X, c.Y)
+			if tile == nil {
+				add(fmt.Sprintf("%s.coords[%d]", path, k), "(%d, %d) is outside the %dx%d map", c.X, c.Y, s.width, s.height)
+				sourcesValid = false
+			} else if !tile.Walkable() {
+				add(fmt.Sprintf("%s.coords[%d]", path, k), "(%d, %d) is on a wall", c.X, c.Y)
+				sourcesValid = false
+			} else {
+				sources = append(sources, tile)
+			}
+		}
+
+		// tiles no longer carry destinations of their own, so a destination is only its coords, region and painted tiles
+		if sourcesValid && len(sources) == 0 {
+			add(path, "%s has no tiles to head for", d.Name)
+		} else if sourcesValid && len(entrances) > 0 && !s.anyReachable(sources, entrances) {
+			add(path, "%s cannot be reached from any entrance", d.Name)
+		}
+	}
+
+	return problems
+}
+
+func (s *State) areaTiles(a *Area) []*Tile {
+	tiles := make([]*Tile, 0, a.Len())
+	for _, p := range a.Points() {
+		tiles = append(tiles, s.GetTile(p.X, p.Y))
+	}
+	return tiles
+}
+
+// anyReachable reports whether the flow field spreading out from sources would reach any of targets
+func (s *State) anyReachable(sources, targets []*Tile) bool {
+	if deltas == nil {
+		initDeltas()
+	}
+	seen := make([]bool, s.width*s.height)
+	queue := make([]*Tile, 0, len(sources))
+	for _, t := range sources {
+		if !seen[t.X*s.height+t.Y] {
+			seen[t.X*s.height+t.Y] = true
+			queue = append(queue, t)
+		}
+	}
+	for len(queue) > 0 {
+		tile := queue[0]
+		queue = queue[1:]
+		for _, n := range getValidNeighbouringTiles(tile, s) {
+			if !seen[n.X*s.height+n.Y] {
+				seen[n.X*s.height+n.Y] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	for _, t := range targets {
+		if seen[t.X*s.height+t.Y] {
+			return true
+		}
+	}
+	return false
+}
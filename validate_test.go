@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeValidateTestMap writes a 20x10 map walled down the middle at x = 10, and a regions file with no regions
+func writeValidateTestMap(t *testing.T, dir string) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 10; y++ {
+			img.Set(x, y, color.White)
+			if x == 10 {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	file, err := os.Create(filepath.Join(dir, "map.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "regions.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// validateTestScenario is a valid scenario for the test map, with one destination, shop, left of the wall
+func validateTestScenario(dir string) map[string]interface{} {
+	var s map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"lat": 51.5, "lng": -0.17,
+		"start": "2018-11-23T07:00:00Z", "end": "2018-11-23T08:00:00Z",
+		"entrance": [{"x": 2, "y": 5, "r": 0.1}],
+		"totalPeople": 10, "totalGroups": 5,
+		"exit": {"coords": [{"x": 1, "y": 1, "r": 1}], "name": "exit"},
+		"Destinations": [{"coords": [{"x": 5, "y": 5, "r": 1}], "name": "shop", "meanUseTime": 60, "useTimeVar": 10,
+			"events": [{"name": "open", "start": "2018-11-23T07:00:00Z", "end": "2018-11-23T08:00:00Z", "popularity": 0.5}]}]
+	}`), &s)
+	if err != nil {
+		panic(err)
+	}
+	s["map"] = filepath.Join(dir, "map.png")
+	s["regions"] = filepath.Join(dir, "regions.json")
+	return s
+}
+
+func object(v interface{}, path ...interface{}) map[string]interface{} {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			v = v.(map[string]interface{})[p]
+		case int:
+			v = v.([]interface{})[p]
+		}
+	}
+	return v.(map[string]interface{})
+}
+
+func TestValidateScenario(t *testing.T) {
+	dir := t.TempDir()
+	writeValidateTestMap(t, dir)
+	tests := []struct {
+		name   string
+		change func(s map[string]interface{})
+		want   []ValidationError
+	}{
+		{"valid", func(s map[string]interface{}) {}, nil},
+		{"entrance on a wall", func(s map[string]interface{}) {
+			object(s, "entrance", 0)["x"] = 10
+		}, []ValidationError{{"$.entrance[0]", "(10, 5) is on a wall, nobody can be added here"}}},
+		{"entrance off the map", func(s map[string]interface{}) {
+			object(s, "entrance", 0)["x"] = 25
+		}, []ValidationError{{"$.entrance[0]", "(25, 5) is outside the 20x10 map"}}},
+		{"destination behind the wall", func(s map[string]interface{}) {
+			object(s, "Destinations", 0, "coords", 0)["x"] = 15
+		}, []ValidationError{{"$.Destinations[0]", "shop cannot be reached from any entrance"}}},
+		{"destination on the wall", func(s map[string]interface{}) {
+			object(s, "Destinations", 0, "coords", 0)["x"] = 10
+		}, []ValidationError{{"$.Destinations[0].coords[0]", "(10, 5) is on a wall"}}},
+		{"destination nowhere", func(s map[string]interface{}) {
+			object(s, "Destinations", 0)["coords"] = []interface{}{}
+		}, []ValidationError{{"$.Destinations[0]", "has neither coords, a regionId nor a colour"}}},
+		{"destination painted nowhere", func(s map[string]interface{}) {
+			object(s, "Destinations", 0)["coords"] = []interface{}{}
+			object(s, "Destinations", 0)["colour"] = "#ff0000"
+		}, []ValidationError{
+			{"$.Destinations[0].colour", "no walkable tiles are painted #ff0000"},
+			{"$.Destinations[0]", "shop has no tiles to head for"},
+		}},
+		{"same flow field file", func(s map[string]interface{}) {
+			object(s, "Destinations", 0)["name"] = "ice bar"
+			object(s, "exit")["name"] = "ice-bar"
+		}, []ValidationError{{"$.exit.name", "flow field file ice-bar.png is also used by $.Destinations[0]"}}},
+		{"event ending before it starts", func(s map[string]interface{}) {
+			object(s, "Destinations", 0, "events", 0)["start"] = "2018-11-23T07:30:00Z"
+			object(s, "Destinations", 0, "events", 0)["end"] = "2018-11-23T07:10:00Z"
+		}, []ValidationError{{"$.Destinations[0].events[0].end", "must be after start (2018-11-23 07:30:00 +0000 UTC)"}}},
+		{"event after the scenario", func(s map[string]interface{}) {
+			object(s, "Destinations", 0, "events", 0)["end"] = "2018-11-23T09:00:00Z"
+		}, []ValidationError{{"$.Destinations[0].events[0].end",
+			"2018-11-23 09:00:00 +0000 UTC is outside the scenario's 2018-11-23 07:00:00 +0000 UTC to 2018-11-23 08:00:00 +0000 UTC"}}},
+		{"unreadable times", func(s map[string]interface{}) {
+			delete(s, "start")
+			object(s, "Destinations", 0, "events", 0)["start"] = "noon"
+		}, []ValidationError{
+			{"$.start", "missing time"},
+			{"$.Destinations[0].events[0].start", `"noon" is not an RFC 3339 time`},
+		}},
+	}
+	for _, test := range tests {
+		s := validateTestScenario(dir)
+		test.change(s)
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "scenario.json")
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, problems := ReadScenario(path)
+		if len(problems) != len(test.want) {
+			t.Errorf("%s: got problems %v, want %v", test.name, problems, test.want)
+			continue
+		}
+		for i := range test.want {
+			if problems[i] != test.want[i] {
+				t.Errorf("%s: got problem %v, want %v", test.name, problems[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestValidateTypeErrorPath(t *testing.T) {
+	dir := t.TempDir()
+	writeValidateTestMap(t, dir)
+	s := validateTestScenario(dir)
+	object(s, "Destinations", 0)["meanUseTime"] = "long"
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "scenario.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, problems := ReadScenario(path)
+	if len(problems) != 1 || problems[0].Path != "$.Destinations[0].meanUseTime" {
+		t.Errorf("got problems %v, want one at $.Destinations[0].meanUseTime", problems)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"github.com/real-time-footfall-analysis/rtfa-simulation/geometry"
-	"github.com/real-time-footfall-analysis/rtfa-simulation/utils"
 	"github.com/satori/go.uuid"
 	"image"
 	"image/color"
@@ -13,20 +12,19 @@ import (
 )
 
 type Tile struct {
-	walkable   bool
-	People     []*Individual
-	HitCount   int
-	Dists      map[DestinationID]float64 // Used internally for dijkstra
-	Directions map[DestinationID]utils.OptionalFloat64
-	X          int
-	Y          int
+	walkable bool
+	People   []*Individual
+	HitCount int
+	X        int
+	Y        int
 
 	blockedNorth bool
 	blockedEast  bool
 	blockedSouth bool
 	blockedWest  bool
 
-	destID uint32
+	cost  float64 // cost of walking one tile across this one
+	speed float64 // fraction of normal walking speed
 }
 
 func (t *Tile) Walkable() bool {
@@ -39,8 +37,18 @@ func (t *Tile) SetWalkable(b bool) {
 	t.walkable = b
 }
 
+func (t *Tile) Cost() float64 {
+	return t.cost
+}
+
+func (t *Tile) Speed() float64 {
+	return t.speed
+}
+
 type State struct {
 	ScenarioName       string
+	OutputDir          string  // where flow fields and renders are written, the scenario name by default
+	Speed              float64 // simulated seconds per real second, 0 for as fast as possible
 	tiles              [][]Tile
 	width              int
 	height             int
@@ -51,12 +59,16 @@ type State struct {
 	BulkSend           bool
 	maxSenders         int
 	currentSenders     int
-	SendUpdates        bool
+	Sink               UpdateSink
+	produced           *[]update // every update handed to the senders, only kept when checking against a mock backend
+	recorder           *TraceRecorder
 	peopletoAdd        int
 	scenario           *Scenario
 	peopleAdded        int
 	peopleCurrent      int
 	groups             []*Group
+	arriving           *Group // the group whose members are still coming in, if people arrive in groups
+	groupsStarted      int
 	playPauseChan      chan bool
 	peopleAddedChan    chan int
 	peopleCurrentChan  chan int
@@ -64,6 +76,20 @@ type State struct {
 	currentSendersChan chan int
 	totalSendsChan     chan int
 	highlightActive    bool
+	Seed               int64
+	rand               *rand.Rand
+	CacheDir           string // where flow fields are cached, the user's cache directory by default
+	mapHash            []byte
+	flowFields         map[DestinationID]*FlowField
+	regionIndex        map[int32]int // region ID to index in Regions
+	regionGrid         [][]int       // for each tile, the indices of the regions which may contain part of it
+	beaconGrid         [][]int       // for each tile, the indices of the beacon regions which may be sensed from it
+	geo                *geoTransform // nil if the scenario doesn't say where the map is
+	metresPerTile      float64
+	personRadius       float64 // PersonRadius in tiles
+	maxRadius          float64 // the largest radius of anyone yet, in tiles
+	nextBody           *body   // drawn for the next person to arrive
+	steering           Steering
 }
 
 func (w *State) GetWidth() int {
@@ -95,39 +121,59 @@ func (w *State) GetTileHighRes(x, y float64) *Tile {
 var counter int = 0
 
 func (w *State) AddRandom() *Individual {
+	return w.addPerson(func() int { return w.rand.Int() % len(w.scenario.Entrances) }, nil)
+}
+
+// addPerson adds someone near the entrance pick chooses, trying again a few times if they land on a wall or someone
+// else. They have their own random personality unless they are given likelihoods.
+func (w *State) addPerson(pick func() int, likelihoods []Likelihood) *Individual {
 
+	// someone who didn't fit keeps their body for the next try, so big people aren't left out of crowded entrances
+	if w.nextBody == nil {
+		body := w.drawBody()
+		w.nextBody = &body
+	}
+	body := *w.nextBody
 	for i := 0; i < 100; i++ {
-		randi := rand.Int() % len(w.scenario.Entrances)
+		randi := pick()
 		x := w.scenario.Entrances[randi].X
 		y := w.scenario.Entrances[randi].Y
-		theta := rand.Float64() * 2 * math.Pi
-		radius := rand.Float64() * w.scenario.Entrances[randi].R
+		theta := w.rand.Float64() * 2 * math.Pi
+		radius := w.rand.Float64() * w.scenario.Entrances[randi].R
 		xf := radius * math.Cos(theta)
 		yf := radius * math.Sin(theta)
 		tile := w.GetTile(int(float64(x)+xf), int(float64(y)+yf))
-		if tile.Walkable() && !w.IntersectsAnyone(float64(x)+xf, float64(y)+yf) {
-			r, g, b := color.YCbCrToRGB(uint8(100), uint8(rand.Intn(256)), uint8(rand.Intn(256)))
+		if tile.Walkable() && !w.IntersectsAnyone(float64(x)+xf, float64(y)+yf, body.radius) {
+			r, g, b := color.YCbCrToRGB(uint8(100), uint8(w.rand.Intn(256)), uint8(w.rand.Intn(256)))
 			c := color.RGBA{r, g, b, 255}
 
-			name := uuid.Must(uuid.NewV4()).String()
+			name := newUUID(w.rand)
 
 			//randSetIndex := rand.Intn(4)
 			updateSender := w.maxSenders > w.currentSenders
-			updateSender = updateSender && rand.Intn(w.scenario.TotalPeople-w.peopleAdded) <= (w.maxSenders-w.currentSenders)
+			updateSender = updateSender && w.rand.Intn(w.scenario.TotalPeople-w.peopleAdded) <= (w.maxSenders-w.currentSenders)
 
+			if likelihoods == nil {
+				likelihoods = w.scenario.GenerateRandomPersonality(w.rand)
+			}
 			person := Individual{
 				Loc:    geometry.NewPoint(float64(x)+xf, float64(y)+yf),
 				Colour: c, UUID: name,
 				Tick:         0,
-				StepSize:     0.2,
-				Likelihoods:  w.scenario.GenerateRandomPersonality(),
+				StepSize:     body.stepSize,
+				Persona:      body.persona,
+				Likelihoods:  likelihoods,
 				RegionIds:    make(map[int32]bool),
 				UpdateSender: updateSender,
+				radius:       body.radius,
+				rand:         rand.New(rand.NewSource(w.rand.Int63())),
 			}
+			w.maxRadius = math.Max(w.maxRadius, person.radius)
+			w.nextBody = nil
 			if updateSender {
 				updateChan := UpdateChan{make(chan update, 50), make(chan bool)}
 				person.UpdateChan = &updateChan
-				go PersonalUpdateDemon(&updateChan, w.SendUpdates)
+				startPersonalSender(&updateChan, w.Sink)
 			}
 			tile.People = append(tile.People, &person)
 			counter++
@@ -146,7 +192,8 @@ func (w *State) AddRandom() *Individual {
 func (w *State) MoveIndividual(person *Individual, theta float64, distance float64) {
 	cx, cy := person.Loc.GetXY()
 	tile := w.GetTile(int(cx), int(cy))
-	_, nx, ny := w.movementIntersects(cx, cy, theta, distance)
+	distance *= tile.speed
+	_, nx, ny := w.movementIntersects(cx, cy, person.radius, theta, distance)
 
 	if nx >= 0 && int(nx) < w.GetWidth() &&
 		ny >= 0 && int(ny) < w.GetHeight() {
@@ -213,18 +260,18 @@ func (w *State) MoveIndividual(person *Individual, theta float64, distance float
 			}
 		}
 	} else {
-		w.movementIntersects(cx, cy, theta, distance)
-		// person is trying to leave! though
-		log.Fatal("cannot leave bounry like this; ", cx, cy, nx, ny, theta/math.Pi, distance)
-
+		// the edge of the map is walled off, but should anyone still get there they stay where they are
+		person.Loc.SetXY(cx, cy)
+		person.LastMoveDist = 0
+		person.MoveDistAvg *= 0.8
 	}
 }
 
 func (w *State) MoveAll() {
 
 	for _, p := range w.allPeople {
-		theta := (rand.Float64() * 2 * math.Pi) * 0.9
-		distance := math.Sqrt(rand.Float64()) / 3
+		theta := (w.rand.Float64() * 2 * math.Pi) * 0.9
+		distance := math.Sqrt(w.rand.Float64()) / 3
 
 		w.MoveIndividual(p, theta, distance)
 
@@ -232,18 +279,32 @@ func (w *State) MoveAll() {
 
 }
 
+// SetSeed resets the world's random stream; two runs of the same scenario with the same seed are identical
+func (s *State) SetSeed(seed int64) {
+	s.Seed = seed
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+// newUUID makes a version 4 UUID from r rather than the system's random source
+func newUUID(r *rand.Rand) string {
+	var u uuid.UUID
+	r.Read(u[:])
+	u.SetVersion(uuid.V4)
+	u.SetVariant(uuid.VariantRFC4122)
+	return u.String()
+}
+
 func (w *State) TickTime() {
 	w.time = w.time.Add(time.Second)
 
 }
 
 func (s *State) FindRegion(id int32) *Region {
-	for i, r := range s.Regions {
-		if r.ID == id {
-			return &s.Regions[i]
-		}
+	i, ok := s.regionIndex[id]
+	if !ok {
+		return nil
 	}
-	return nil
+	return &s.Regions[i]
 }
 
 func (s *State) MakeChannes() {
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// gridWorld builds a map from rows of text, # being a wall, g grass (#00c800) and anything else floor, with an empty
+// scenario and one metre tiles
+func gridWorld(rows ...string) *State {
+	w := &State{width: len(rows[0]), height: len(rows), scenario: &Scenario{}, metresPerTile: 1}
+	background := image.NewRGBA(image.Rect(0, 0, w.width, w.height))
+	w.tiles = make([][]Tile, w.width)
+	for x := range w.tiles {
+		w.tiles[x] = make([]Tile, w.height)
+		for y := range w.tiles[x] {
+			w.tiles[x][y] = Tile{X: x, Y: y, walkable: rows[y][x] != '#', cost: 1, speed: 1}
+			switch rows[y][x] {
+			case '#':
+				background.Set(x, y, color.Black)
+			case 'g':
+				background.Set(x, y, color.RGBA{G: 0xc8, A: 0xff})
+			default:
+				background.Set(x, y, color.White)
+			}
+		}
+	}
+	w.background = background
+	return w
+}
+
+// withDestination gives the world a single destination at x, y and an exit, returning the destination's ID
+func (w *State) withDestination(x, y int) DestinationID {
+	w.scenario.Destinations = []Destination{{Name: "shop", Coords: []Coord{{X: x, Y: y}}}}
+	w.scenario.Exit = Destination{Name: "exit", Coords: []Coord{{X: x, Y: y}}}
+	w.setupDestinations()
+	return w.scenario.Destinations[0].ID
+}